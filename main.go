@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/brettcodling/Kubessh/pkg/cli"
+	"github.com/brettcodling/Kubessh/pkg/control"
 	"github.com/brettcodling/Kubessh/pkg/database"
 	"github.com/brettcodling/Kubessh/pkg/directory"
 	"github.com/brettcodling/Kubessh/pkg/kubectl"
@@ -14,7 +16,8 @@ import (
 )
 
 func init() {
-	if os.Getenv("DISABLE_SYSLOG") != "1" {
+	// Subcommands run from a terminal and log to stderr instead.
+	if os.Getenv("DISABLE_SYSLOG") != "1" && len(os.Args) < 2 {
 		syslog, err := syslog.New(syslog.LOG_INFO, "Kubessh")
 		if err != nil {
 			log.Fatal("Unable to connect to syslog")
@@ -24,7 +27,12 @@ func init() {
 }
 
 func main() {
-	defer database.DB.Close()
+	if len(os.Args) > 1 {
+		code := cli.Run(os.Args[1:])
+		database.Close()
+		os.Exit(code)
+	}
+	defer database.Close()
 
 	delay := os.Getenv(("DELAY_STARTUP"))
 	if delay != "" {
@@ -42,7 +50,10 @@ func main() {
 		kubectl.AddContexts()
 		kubectl.AddNamespaces()
 		pods := systray.AddMenuItem("Pods", "")
+		kubectl.AddTrayPods()
 		kubectl.AddPortForwarding()
+		kubectl.AddLogRecording()
+		kubectl.AddLogAlerts()
 		systray.AddSeparator()
 		settings := systray.AddMenuItem("Settings", "")
 		refreshItem := systray.AddMenuItem("Refresh", "")
@@ -64,7 +75,16 @@ func main() {
 		if connected {
 			refresh(true)
 		}
-	}, func() {})
+		go func() {
+			kubectl.MigratePortForwards()
+			kubectl.RestorePortForwards()
+		}()
+		go func() {
+			if err := control.Serve(); err != nil {
+				log.Println(err)
+			}
+		}()
+	}, control.Close)
 }
 
 func getIcon() []byte {
@@ -0,0 +1,507 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/brettcodling/Kubessh/pkg/database"
+	"github.com/brettcodling/Kubessh/pkg/kubectl"
+	"golang.org/x/term"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitOK       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitNotFound = 3
+)
+
+const usage = `Usage: kubessh <command> [flags] [args]
+
+Commands:
+  contexts                      list contexts
+  use-context NAME              select a context
+  namespaces                    list namespaces
+  use-namespace NAME            select a namespace
+  pods                          list pods
+  forward list                  list saved port forwards
+  forward start POD [FROM:TO|PORT...]
+                                forward a pod's saved ports until interrupted
+  forward stop POD|ID           stop a forward started with "forward start"
+  logs [-f] [--tail N] [--previous] [--since D | --since-time T]
+       [--all-containers | -c CONTAINER] POD
+  tail [--tail N] (-l SELECTOR | KIND/NAME)
+                                follow every pod of a selector or workload
+  exec [-c CONTAINER] POD [-- COMMAND...]
+                                without a command, the first shell found runs
+
+Every command accepts --kubeconfig, --context and --namespace to override the
+selection for that call only, and the listing commands accept --json.
+
+Exit codes: 0 success, 1 error, 2 usage error, 3 not found.
+`
+
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+// command is the state shared by every subcommand.
+type command struct {
+	flags      *flag.FlagSet
+	json       *bool
+	kubeconfig *string
+	context    *string
+	namespace  *string
+}
+
+func newCommand(name string) *command {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	return &command{
+		flags:      flags,
+		json:       flags.Bool("json", false, "print JSON"),
+		kubeconfig: flags.String("kubeconfig", "", "kubeconfig file"),
+		context:    flags.String("context", "", "context"),
+		namespace:  flags.String("namespace", "", "namespace"),
+	}
+}
+
+// parse parses flags wherever they appear among the positional arguments.
+// Anything after "--" is returned untouched as rest.
+func (c *command) parse(args []string) (positional, rest []string, err error) {
+	for i, arg := range args {
+		if arg == "--" {
+			rest = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+	positional = []string{}
+	for {
+		if err := c.flags.Parse(args); err != nil {
+			return nil, nil, usageError{err.Error()}
+		}
+		args = c.flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if err := kubectl.SetTarget(*c.kubeconfig, *c.context, *c.namespace); err != nil {
+		return nil, nil, usageError{err.Error()}
+	}
+
+	return positional, rest, nil
+}
+
+func (c *command) print(value interface{}, table func(w io.Writer)) error {
+	if *c.json {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	table(w)
+
+	return w.Flush()
+}
+
+// Run executes the subcommand in args and returns the process exit code.
+func Run(args []string) int {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	err := run(ctx, args)
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintln(stderr, err)
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	case errors.Is(err, kubectl.ErrNotFound):
+		fmt.Fprintln(stderr, err)
+		return ExitNotFound
+	default:
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError{"no command given"}
+	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	case "contexts":
+		return contexts(args[1:])
+	case "use-context":
+		return useContext(args[1:])
+	case "namespaces":
+		return namespaces(ctx, args[1:])
+	case "use-namespace":
+		return useNamespace(args[1:])
+	case "pods":
+		return pods(ctx, args[1:])
+	case "forward":
+		return forward(ctx, args[1:])
+	case "logs":
+		return logs(ctx, args[1:])
+	case "tail":
+		return tail(ctx, args[1:])
+	case "exec":
+		return execute(ctx, args[1:])
+	}
+
+	return usageError{"unknown command " + args[0]}
+}
+
+func contexts(args []string) error {
+	c := newCommand("contexts")
+	if _, _, err := c.parse(args); err != nil {
+		return err
+	}
+	contexts, err := kubectl.ListContexts()
+	if err != nil {
+		return err
+	}
+
+	return c.print(contexts, func(w io.Writer) {
+		fmt.Fprintln(w, "CURRENT\tNAME\tCLUSTER\tUSER\tNAMESPACE\tFILE")
+		for _, context := range contexts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", marker(context.InUse), context.Name, context.Cluster, context.User, context.Namespace, context.File)
+		}
+	})
+}
+
+func useContext(args []string) error {
+	c := newCommand("use-context")
+	positional, _, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"use-context takes one context name"}
+	}
+	if err := kubectl.UseContext(positional[0], *c.kubeconfig); err != nil {
+		if errors.Is(err, kubectl.ErrTargetOverridden) {
+			return usageError{"use-context can't be combined with --context or --namespace"}
+		}
+		return err
+	}
+	fmt.Fprintln(stdout, "Switched to context "+positional[0])
+
+	return nil
+}
+
+type namespaceOutput struct {
+	Name    string `json:"name"`
+	Current bool   `json:"current"`
+}
+
+func namespaces(ctx context.Context, args []string) error {
+	c := newCommand("namespaces")
+	if _, _, err := c.parse(args); err != nil {
+		return err
+	}
+	names, err := kubectl.ListNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	current := kubectl.CurrentNamespace()
+	output := []namespaceOutput{}
+	for _, name := range names {
+		output = append(output, namespaceOutput{
+			Name:    name,
+			Current: name == current,
+		})
+	}
+
+	return c.print(output, func(w io.Writer) {
+		fmt.Fprintln(w, "CURRENT\tNAME")
+		for _, namespace := range output {
+			fmt.Fprintf(w, "%s\t%s\n", marker(namespace.Current), namespace.Name)
+		}
+	})
+}
+
+func useNamespace(args []string) error {
+	c := newCommand("use-namespace")
+	positional, _, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"use-namespace takes one namespace name"}
+	}
+	if err := kubectl.UseNamespace(positional[0]); err != nil {
+		if errors.Is(err, kubectl.ErrTargetOverridden) {
+			return usageError{"use-namespace can't be combined with --context or --namespace"}
+		}
+		return err
+	}
+	fmt.Fprintln(stdout, "Switched to namespace "+positional[0])
+
+	return nil
+}
+
+func pods(ctx context.Context, args []string) error {
+	c := newCommand("pods")
+	if _, _, err := c.parse(args); err != nil {
+		return err
+	}
+	pods, err := kubectl.ListPods(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.print(pods, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tREADY\tSTATUS\tRESTARTS\tAGE")
+		for _, pod := range pods {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pod.Name, pod.Ready, pod.Status, pod.Restarts, pod.Age)
+		}
+	})
+}
+
+type forwardOutput struct {
+	kubectl.SavedPortForward
+	PID int `json:"pid,omitempty"`
+}
+
+func forward(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError{"forward needs list, start or stop"}
+	}
+	c := newCommand("forward " + args[0])
+	positional, _, err := c.parse(args[1:])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		output := []forwardOutput{}
+		for _, saved := range kubectl.SavedPortForwards() {
+			output = append(output, forwardOutput{
+				SavedPortForward: saved,
+				PID:              forwardPID(saved.ID),
+			})
+		}
+		return c.print(output, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tCONTEXT\tNAMESPACE\tPOD\tPORTS\tSTATUS")
+			for _, forward := range output {
+				status := "stopped"
+				if forward.PID != 0 {
+					status = "running (pid " + strconv.Itoa(forward.PID) + ")"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", forward.ID, forward.Context, forward.Namespace, forward.Pod, joinMappings(forward.Mappings), status)
+			}
+		})
+	case "start":
+		if len(positional) < 1 {
+			return usageError{"forward start takes a pod and optionally FROM:TO mappings"}
+		}
+		saved, err := kubectl.GetSavedPortForward(positional[0])
+		if len(positional) > 1 {
+			saved = kubectl.SavedPortForward{ID: kubectl.ForwardID(positional[0]), Pod: positional[0]}
+			for _, value := range positional[1:] {
+				mapping, err := kubectl.ParsePortMapping(value)
+				if err != nil {
+					return err
+				}
+				saved.Mappings = append(saved.Mappings, mapping)
+			}
+		} else if err != nil {
+			return err
+		}
+		if pid := forwardPID(saved.ID); pid != 0 {
+			return fmt.Errorf("%s is already forwarded by pid %d", saved.Pod, pid)
+		}
+		database.Set("CLI-FORWARD-"+saved.ID, strconv.Itoa(os.Getpid()))
+		defer database.Delete("CLI-FORWARD-" + saved.ID)
+		fmt.Fprintf(stdout, "Forwarding %s to %s, interrupt to stop\n", joinMappings(saved.Mappings), saved.Pod)
+		err = saved.Run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	case "stop":
+		if len(positional) != 1 {
+			return usageError{"forward stop takes one pod name or forward ID"}
+		}
+		// A forward of another context or namespace is stopped by the ID
+		// "forward list" shows.
+		pid := forwardPID(positional[0])
+		if pid == 0 {
+			pid = forwardPID(kubectl.ForwardID(positional[0]))
+		}
+		if pid == 0 {
+			return fmt.Errorf("running forward for %s %w", positional[0], kubectl.ErrNotFound)
+		}
+		return syscall.Kill(pid, syscall.SIGTERM)
+	}
+
+	return usageError{"unknown forward command " + args[0]}
+}
+
+func joinMappings(mappings []kubectl.PortMapping) string {
+	values := []string{}
+	for _, mapping := range mappings {
+		values = append(values, mapping.String())
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// forwardPID returns the process running "forward start" for the forward
+// with the given ID, or 0. The saved pid is forgotten once it no longer
+// belongs to that command, as after the forward was killed.
+func forwardPID(id string) int {
+	pid, err := strconv.Atoi(database.Get("CLI-FORWARD-" + id))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	if !isForwardProcess(pid) {
+		database.Delete("CLI-FORWARD-" + id)
+		return 0
+	}
+
+	return pid
+}
+
+// isForwardProcess reports whether pid is this program running
+// "forward start", and not a process that has been given a reused pid.
+func isForwardProcess(pid int) bool {
+	proc := "/proc/" + strconv.Itoa(pid)
+	exe, err := os.Readlink(proc + "/exe")
+	if err != nil {
+		return false
+	}
+	self, err := os.Executable()
+	if err != nil || strings.TrimSuffix(exe, " (deleted)") != self {
+		return false
+	}
+	cmdline, err := os.ReadFile(proc + "/cmdline")
+	if err != nil {
+		return false
+	}
+	args := strings.Split(string(cmdline), "\x00")
+
+	return len(args) > 2 && args[1] == "forward" && args[2] == "start"
+}
+
+func logs(ctx context.Context, args []string) error {
+	c := newCommand("logs")
+	follow := c.flags.Bool("f", false, "follow the log")
+	tail := c.flags.String("tail", "", "lines to show")
+	timestamps := c.flags.Bool("timestamps", false, "include timestamps")
+	previous := c.flags.Bool("previous", false, "logs of the previous container instance")
+	since := c.flags.String("since", "", "only logs newer than a duration such as 1h")
+	sinceTime := c.flags.String("since-time", "", "only logs after an RFC3339 time")
+	allContainers := c.flags.Bool("all-containers", false, "logs of every container")
+	container := c.flags.String("c", "", "container")
+	positional, _, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"logs takes one pod name"}
+	}
+	err = kubectl.StreamLogs(ctx, kubectl.LogOptions{
+		Pod:           positional[0],
+		Container:     *container,
+		Follow:        *follow,
+		Tail:          *tail,
+		Timestamps:    *timestamps,
+		Previous:      *previous,
+		Since:         *since,
+		SinceTime:     *sinceTime,
+		AllContainers: *allContainers,
+	}, stdout)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// tailColors are the ANSI colors given to each pod's prefix in turn.
+var tailColors = []string{"36", "32", "35", "33", "34", "31"}
+
+func tail(ctx context.Context, args []string) error {
+	c := newCommand("tail")
+	lines := c.flags.String("tail", "10", "lines to show from each container")
+	selector := c.flags.String("l", "", "label selector")
+	positional, _, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	target := kubectl.TailTarget{Selector: *selector}
+	switch {
+	case len(positional) == 1 && *selector == "":
+		target.Workload = positional[0]
+	case len(positional) != 0 || *selector == "":
+		return usageError{"tail takes a label selector or one workload"}
+	}
+	color := term.IsTerminal(int(os.Stdout.Fd()))
+
+	return kubectl.Tail(ctx, target, *lines, func(line kubectl.TailLine) {
+		prefix := line.Prefix()
+		if color {
+			prefix = "\x1b[" + tailColors[line.Color%len(tailColors)] + "m" + prefix + "\x1b[0m"
+		}
+		fmt.Fprintln(stdout, prefix, line.Text)
+	})
+}
+
+func execute(ctx context.Context, args []string) error {
+	c := newCommand("exec")
+	container := c.flags.String("c", "", "container")
+	positional, rest, err := c.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"exec takes one pod name"}
+	}
+	if len(rest) == 0 {
+		if rest, err = kubectl.ShellCommand(ctx, positional[0], *container); err != nil {
+			return err
+		}
+	}
+
+	return kubectl.Exec(ctx, positional[0], *container, rest)
+}
+
+func marker(current bool) string {
+	if current {
+		return "*"
+	}
+
+	return ""
+}
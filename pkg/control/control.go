@@ -0,0 +1,189 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/brettcodling/Kubessh/pkg/kubectl"
+)
+
+// The control API lets local tools query and drive the running tray over a
+// Unix socket:
+//
+//	GET    /context              current context and namespace
+//	GET    /port-forwards        active port-forwards
+//	POST   /port-forwards        start one, body {"pod", "mappings": [{"from", "to"}]}
+//	DELETE /port-forwards/{id}   stop one, by ID or by pod in the selected namespace
+//	POST   /pods/open            open the Pods window
+//	POST   /pods/{name}/open     open a pod's window
+//	GET    /events               newline delimited JSON events
+
+var listener net.Listener
+
+// SocketPath is $XDG_RUNTIME_DIR/kubessh/kubessh.sock. There is no fallback
+// when XDG_RUNTIME_DIR is not set, as other users may be able to reach a
+// socket anywhere else.
+func SocketPath() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return "", errors.New("XDG_RUNTIME_DIR is not set")
+	}
+
+	return filepath.Join(dir, "kubessh", "kubessh.sock"), nil
+}
+
+// Serve listens on the control socket until Close is called.
+func Serve() error {
+	path, err := SocketPath()
+	if err != nil {
+		return err
+	}
+	// The socket is only ever created inside a directory only the user can
+	// enter, so it is never reachable by others before it is listening.
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("another Kubessh is already listening on " + path)
+	}
+	os.Remove(path)
+
+	listener, err = net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	err = http.Serve(listener, newMux())
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func Close() {
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /context", getContext)
+	mux.HandleFunc("GET /port-forwards", listPortForwards)
+	mux.HandleFunc("POST /port-forwards", startPortForward)
+	mux.HandleFunc("DELETE /port-forwards/{pod...}", stopPortForward)
+	mux.HandleFunc("POST /pods/open", openPods)
+	mux.HandleFunc("POST /pods/{name}/open", openPod)
+	mux.HandleFunc("GET /events", events)
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, kubectl.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, kubectl.ErrInvalid):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+type contextResponse struct {
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	Cluster   string `json:"cluster"`
+	File      string `json:"file"`
+}
+
+func getContext(w http.ResponseWriter, r *http.Request) {
+	context := kubectl.CurrentContext()
+	writeJSON(w, http.StatusOK, contextResponse{
+		Context:   context.Name,
+		Namespace: kubectl.CurrentNamespace(),
+		Cluster:   context.Cluster,
+		File:      context.File,
+	})
+}
+
+func listPortForwards(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, kubectl.ActivePortForwards())
+}
+
+func startPortForward(w http.ResponseWriter, r *http.Request) {
+	var forward kubectl.PortForward
+	if err := json.NewDecoder(r.Body).Decode(&forward); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := kubectl.StartPortForward(forward.Pod, forward.Mappings); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func stopPortForward(w http.ResponseWriter, r *http.Request) {
+	if err := kubectl.StopPortForward(r.PathValue("pod")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func openPods(w http.ResponseWriter, r *http.Request) {
+	go kubectl.OpenPods()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func openPod(w http.ResponseWriter, r *http.Request) {
+	if err := kubectl.OpenPod(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+	events, unsubscribe := kubectl.Subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				log.Println(err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -2,50 +2,123 @@ package database
 
 import (
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/brettcodling/Kubessh/pkg/directory"
 	"github.com/brettcodling/Kubessh/pkg/notify"
 )
 
-var DB *bolt.DB
+// bolt locks the file while open, so it is closed when idle.
+const (
+	releaseAfter = time.Second
+	openTimeout  = 10 * time.Second
+)
+
+var (
+	mu      sync.Mutex
+	db      *bolt.DB
+	release *time.Timer
+)
 
 func init() {
-	var err error
-	DB, err = bolt.Open(directory.Dir+"/settings.db", 0600, nil)
+	err := use(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte("Settings"))
+			return err
+		})
+	})
 	if err != nil {
 		notify.Warning("ERROR!", err.Error())
 		log.Fatal(err)
 	}
+}
 
-	DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Settings"))
-		if b == nil {
-			var err error
-			b, err = tx.CreateBucket([]byte("Settings"))
-			if err != nil {
-				notify.Warning("ERROR!", err.Error())
-				log.Fatal(err)
-			}
+// use calls fn with the database, opening it first when it has been
+// released.
+func use(fn func(db *bolt.DB) error) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		opened, err := bolt.Open(directory.Dir+"/settings.db", 0600, &bolt.Options{Timeout: openTimeout})
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+		db = opened
+	}
+	if release == nil {
+		release = time.AfterFunc(releaseAfter, Close)
+	} else {
+		release.Reset(releaseAfter)
+	}
+
+	return fn(db)
+}
+
+// Close releases the database. The next call opens it again.
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		return
+	}
+	if err := db.Close(); err != nil {
+		log.Println(err)
+	}
+	db = nil
 }
 
 func Get(key string) string {
 	var value string
-	DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Settings"))
-		value = string(b.Get([]byte(key)))
-		return nil
+	err := use(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("Settings"))
+			value = string(b.Get([]byte(key)))
+			return nil
+		})
 	})
+	if err != nil {
+		log.Println(err)
+	}
 	return value
 }
 
 func Set(key, value string) error {
-	return DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Settings"))
-		err := b.Put([]byte(key), []byte(value))
-		return err
+	return use(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("Settings"))
+			err := b.Put([]byte(key), []byte(value))
+			return err
+		})
+	})
+}
+
+func Delete(key string) error {
+	return use(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("Settings"))
+			return b.Delete([]byte(key))
+		})
 	})
 }
+
+// List returns every setting whose key starts with prefix, keyed by the rest
+// of the key.
+func List(prefix string) map[string]string {
+	values := make(map[string]string)
+	err := use(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte("Settings")).Cursor()
+			for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+				values[strings.TrimPrefix(string(k), prefix)] = string(v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return values
+}
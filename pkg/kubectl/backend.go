@@ -0,0 +1,213 @@
+package kubectl
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/brettcodling/Kubessh/pkg/notify"
+)
+
+// Backend talks to the cluster on behalf of the tray.
+type Backend interface {
+	CheckConnection(ctx context.Context) error
+	ListNamespaces(ctx context.Context) ([]string, error)
+	ListPods(ctx context.Context) ([]*Pod, error)
+	GetPod(ctx context.Context, name string) (*Pod, error)
+	GetService(ctx context.Context, name string) (*Service, error)
+	// WatchPods calls handler for every change to the pods in the current
+	// namespace until ctx is done or the stream ends. The stream starts
+	// with an ADDED event for each existing pod.
+	WatchPods(ctx context.Context, handler func(PodEvent)) error
+	Exec(ctx context.Context, opts ExecOptions) error
+	Logs(ctx context.Context, opts LogOptions, out io.Writer) error
+	PortForward(ctx context.Context, pod string, ports []string) error
+	// ExecCommand and LogsCommand return the command line a terminal
+	// emulator should run for an interactive session.
+	ExecCommand(pod, container string, command []string) []string
+	LogsCommand(opts LogOptions) []string
+}
+
+type PodEvent struct {
+	Type string
+	Pod  *Pod
+}
+
+type ExecOptions struct {
+	Pod       string
+	Container string
+	Command   []string
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	TTY       bool
+	// TerminalSize delivers size changes of the local terminal for TTY
+	// sessions. It may be nil.
+	TerminalSize <-chan TerminalSize
+}
+
+type TerminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+type LogOptions struct {
+	Pod        string
+	Container  string
+	Follow     bool
+	Tail       string
+	Timestamps bool
+	Previous   bool
+	// Since is a duration such as 1h and SinceTime an RFC3339 time. At most
+	// one of them is set.
+	Since     string
+	SinceTime string
+	// AllContainers streams every container of the pod, each line prefixed
+	// with [pod/<pod>/<container>] as kubectl does.
+	AllContainers bool
+}
+
+const (
+	BackendKubectl = "kubectl"
+	BackendAPI     = "api"
+)
+
+var Backends = []string{BackendKubectl, BackendAPI}
+
+// ClusterTarget pins backend calls to a kubeconfig file, context and
+// namespace. The zero value follows the tray's selection.
+type ClusterTarget struct {
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+func currentClusterTarget() ClusterTarget {
+	return ClusterTarget{
+		Kubeconfig: activeKubeconfig,
+		Context:    getCurrentContext().Name,
+		Namespace:  getCurrentNamespace().Name,
+	}
+}
+
+var (
+	backendOverride Backend
+
+	backendMu sync.Mutex
+	// apiBackends holds the API backend of each cluster target, since
+	// building one reads the kubeconfig and sets up a new client.
+	apiBackends = make(map[ClusterTarget]Backend)
+)
+
+// SetBackend forces every call to use b regardless of the configured backend.
+func SetBackend(b Backend) {
+	backendOverride = b
+}
+
+func getBackend() Backend {
+	if backendOverride != nil {
+		return backendOverride
+	}
+	if backendString == BackendAPI {
+		return apiBackendFor(currentClusterTarget())
+	}
+
+	return KubectlBackend{}
+}
+
+// backendFor returns a backend that keeps talking to target when the tray's
+// selection changes.
+func backendFor(target ClusterTarget) Backend {
+	if backendOverride != nil || target == (ClusterTarget{}) {
+		return getBackend()
+	}
+	if backendString == BackendAPI {
+		return apiBackendFor(target)
+	}
+
+	return KubectlBackend{Target: &target}
+}
+
+// apiBackendFor returns the API backend for target, building it the first
+// time. When it can't be built the error is reported once and every call
+// fails with it, rather than quietly using kubectl instead.
+func apiBackendFor(target ClusterTarget) Backend {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if backend, ok := apiBackends[target]; ok {
+		return backend
+	}
+	var backend Backend
+	api, err := newAPIBackend(&target)
+	if err != nil {
+		log.Println(err)
+		notify.Warning("ERROR!", "API backend: "+err.Error())
+		backend = unavailableBackend{err: err, target: target}
+	} else {
+		backend = api
+	}
+	apiBackends[target] = backend
+
+	return backend
+}
+
+// resetBackends drops the API backends built so far, so that changes to the
+// kubeconfig files are picked up.
+func resetBackends() {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	apiBackends = make(map[ClusterTarget]Backend)
+}
+
+// unavailableBackend stands in for an API backend whose kubeconfig could not
+// be used.
+type unavailableBackend struct {
+	err    error
+	target ClusterTarget
+}
+
+func (b unavailableBackend) CheckConnection(ctx context.Context) error {
+	return b.err
+}
+
+func (b unavailableBackend) ListNamespaces(ctx context.Context) ([]string, error) {
+	return nil, b.err
+}
+
+func (b unavailableBackend) ListPods(ctx context.Context) ([]*Pod, error) {
+	return nil, b.err
+}
+
+func (b unavailableBackend) GetPod(ctx context.Context, name string) (*Pod, error) {
+	return nil, b.err
+}
+
+func (b unavailableBackend) GetService(ctx context.Context, name string) (*Service, error) {
+	return nil, b.err
+}
+
+func (b unavailableBackend) WatchPods(ctx context.Context, handler func(PodEvent)) error {
+	return b.err
+}
+
+func (b unavailableBackend) Exec(ctx context.Context, opts ExecOptions) error {
+	return b.err
+}
+
+func (b unavailableBackend) Logs(ctx context.Context, opts LogOptions, out io.Writer) error {
+	return b.err
+}
+
+func (b unavailableBackend) PortForward(ctx context.Context, pod string, ports []string) error {
+	return b.err
+}
+
+// The session commands run the CLI, which reports the error in the terminal.
+func (b unavailableBackend) ExecCommand(pod, container string, command []string) []string {
+	return (&APIBackend{Target: &b.target}).ExecCommand(pod, container, command)
+}
+
+func (b unavailableBackend) LogsCommand(opts LogOptions) []string {
+	return (&APIBackend{Target: &b.target}).LogsCommand(opts)
+}
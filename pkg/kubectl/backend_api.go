@@ -0,0 +1,388 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// APIBackend implements Backend by talking to the API server with client-go.
+// Client can be a fake clientset, in which case Config may be nil and only
+// the listing calls are usable.
+type APIBackend struct {
+	Client    kubernetes.Interface
+	Config    *rest.Config
+	Namespace string
+	// Target is the cluster the backend is pinned to, or nil when it
+	// follows the tray's selection.
+	Target *ClusterTarget
+}
+
+var errNoConfig = errors.New("this action needs a connection to a real cluster")
+
+// NewAPIBackend builds an APIBackend from the same kubeconfig kubectl uses.
+func NewAPIBackend() (*APIBackend, error) {
+	return newAPIBackend(nil)
+}
+
+// newAPIBackend builds an APIBackend pinned to target, or following the
+// tray's selection when target is nil.
+func newAPIBackend(target *ClusterTarget) (*APIBackend, error) {
+	rules := loadingRules()
+	overrides := &clientcmd.ConfigOverrides{}
+	if target != nil {
+		if target.Kubeconfig != "" {
+			rules.ExplicitPath = target.Kubeconfig
+		}
+		overrides.CurrentContext = target.Context
+		overrides.Context.Namespace = target.Namespace
+	} else if isolated {
+		overrides.CurrentContext = sessionContext
+		overrides.Context.Namespace = sessionNamespace
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &APIBackend{
+		Client:    client,
+		Config:    config,
+		Namespace: namespace,
+	}
+	if target != nil {
+		pinned := *target
+		pinned.Namespace = namespace
+		backend.Target = &pinned
+	}
+
+	return backend, nil
+}
+
+func (b *APIBackend) CheckConnection(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return b.Client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+func (b *APIBackend) ListNamespaces(ctx context.Context) ([]string, error) {
+	list, err := b.Client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	namespaces := []string{}
+	for _, namespace := range list.Items {
+		namespaces = append(namespaces, namespace.Name)
+	}
+
+	return namespaces, nil
+}
+
+func (b *APIBackend) ListPods(ctx context.Context) ([]*Pod, error) {
+	pods := []*Pod{}
+	options := metav1.ListOptions{Limit: podChunkLimit}
+	for {
+		list, err := b.Client.CoreV1().Pods(b.Namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			pods = append(pods, newPod(&list.Items[i]))
+		}
+		if list.Continue == "" {
+			return pods, nil
+		}
+		options.Continue = list.Continue
+	}
+}
+
+func (b *APIBackend) GetPod(ctx context.Context, name string) (*Pod, error) {
+	pod, err := b.Client.CoreV1().Pods(b.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return newPod(pod), nil
+}
+
+func (b *APIBackend) GetService(ctx context.Context, name string) (*Service, error) {
+	service, err := b.Client.CoreV1().Services(b.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return newService(service), nil
+}
+
+func (b *APIBackend) WatchPods(ctx context.Context, handler func(PodEvent)) error {
+	watcher, err := b.Client.CoreV1().Pods(b.Namespace).Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				return apierrors.FromObject(event.Object)
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			handler(PodEvent{
+				Type: string(event.Type),
+				Pod:  newPod(pod),
+			})
+		}
+	}
+}
+
+func (b *APIBackend) Exec(ctx context.Context, opts ExecOptions) error {
+	if b.Config == nil {
+		return errNoConfig
+	}
+	req := b.Client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(b.Namespace).
+		Name(opts.Pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(b.Config, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+	streamOptions := remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Tty:    opts.TTY,
+	}
+	if !opts.TTY {
+		streamOptions.Stderr = opts.Stderr
+	}
+	if opts.TerminalSize != nil {
+		streamOptions.TerminalSizeQueue = terminalSizeQueue(opts.TerminalSize)
+	}
+
+	return executor.StreamWithContext(ctx, streamOptions)
+}
+
+type terminalSizeQueue <-chan TerminalSize
+
+func (q terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q
+	if !ok {
+		return nil
+	}
+
+	return &remotecommand.TerminalSize{Width: size.Width, Height: size.Height}
+}
+
+func (b *APIBackend) Logs(ctx context.Context, opts LogOptions, out io.Writer) error {
+	if err := validateLogOptions(opts); err != nil {
+		return err
+	}
+	if opts.AllContainers {
+		return b.allContainerLogs(ctx, opts, out)
+	}
+	logOptions := &corev1.PodLogOptions{
+		Container:  opts.Container,
+		Follow:     opts.Follow,
+		Timestamps: opts.Timestamps,
+		Previous:   opts.Previous,
+	}
+	if tail, err := strconv.ParseInt(opts.Tail, 10, 64); err == nil && tail >= 0 {
+		logOptions.TailLines = &tail
+	}
+	if opts.Since != "" {
+		since, _ := time.ParseDuration(opts.Since)
+		seconds := int64(since.Seconds())
+		logOptions.SinceSeconds = &seconds
+	}
+	if opts.SinceTime != "" {
+		sinceTime, _ := time.Parse(time.RFC3339, opts.SinceTime)
+		logOptions.SinceTime = &metav1.Time{Time: sinceTime}
+	}
+	stream, err := b.Client.CoreV1().Pods(b.Namespace).GetLogs(opts.Pod, logOptions).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_, err = io.Copy(out, stream)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// allContainerLogs streams every container at once, which the API has no
+// single call for, prefixing lines the way kubectl --prefix does.
+func (b *APIBackend) allContainerLogs(ctx context.Context, opts LogOptions, out io.Writer) error {
+	pod, err := b.GetPod(ctx, opts.Pod)
+	if err != nil {
+		return err
+	}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	for _, container := range pod.Containers {
+		wg.Add(1)
+		go func(container string) {
+			defer wg.Done()
+			containerOpts := opts
+			containerOpts.Container = container
+			containerOpts.AllContainers = false
+			writer := &prefixWriter{
+				prefix: "[pod/" + opts.Pod + "/" + container + "] ",
+				out:    out,
+				mu:     &mu,
+			}
+			err := b.Logs(ctx, containerOpts, writer)
+			writer.flush()
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(container.Name)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// prefixWriter writes whole lines with a prefix, holding mu for each line so
+// that concurrent writers sharing out never interleave within a line.
+type prefixWriter struct {
+	prefix  string
+	out     io.Writer
+	mu      *sync.Mutex
+	partial []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.mu.Lock()
+		_, err := io.WriteString(w.out, w.prefix+string(w.partial[:i+1]))
+		w.mu.Unlock()
+		w.partial = w.partial[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (w *prefixWriter) flush() {
+	if len(w.partial) > 0 {
+		w.Write([]byte{'\n'})
+	}
+}
+
+func (b *APIBackend) PortForward(ctx context.Context, pod string, ports []string) error {
+	if b.Config == nil {
+		return errNoConfig
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(b.Config)
+	if err != nil {
+		return err
+	}
+	url := b.Client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(b.Namespace).
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	stopCh := make(chan struct{})
+	forwarder, err := portforward.New(dialer, ports, stopCh, make(chan struct{}), io.Discard, io.Discard)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stopCh)
+		case <-done:
+		}
+	}()
+
+	return forwarder.ForwardPorts()
+}
+
+func (b *APIBackend) ExecCommand(pod, container string, command []string) []string {
+	args := append([]string{selfPath(), "exec"}, KubectlBackend{Target: b.Target}.args("-c", container, pod, "--")...)
+
+	return append(args, command...)
+}
+
+func (b *APIBackend) LogsCommand(opts LogOptions) []string {
+	// The CLI always prefixes lines from all containers and has no
+	// --prefix flag.
+	args := []string{}
+	for _, arg := range logsArgs(opts)[1:] {
+		if arg != "--prefix=true" {
+			args = append(args, arg)
+		}
+	}
+
+	return append([]string{selfPath(), "logs"}, KubectlBackend{Target: b.Target}.args(args...)...)
+}
+
+func selfPath() string {
+	path, err := os.Executable()
+	if err != nil {
+		return os.Args[0]
+	}
+
+	return path
+}
@@ -0,0 +1,245 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// The API backend is checked against the kubectl backend replaying
+// testdata/kubectl, with the fake clientset holding the same objects.
+
+func recordedOutput(t *testing.T, name string, object any) {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", "kubectl", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recording Recording
+	if err := json.Unmarshal(raw, &recording); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(recording.Stdout), object); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func recordedPods(t *testing.T) []corev1.Pod {
+	t.Helper()
+	var list corev1.PodList
+	recordedOutput(t, "get-pods.json", &list)
+
+	return list.Items
+}
+
+// comparePods ignores Age, which is worked out from the current time.
+func comparePods(t *testing.T, got, want []*Pod) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d pods, want %d", len(got), len(want))
+	}
+	for i := range got {
+		gotPod, wantPod := *got[i], *want[i]
+		gotPod.Age, wantPod.Age = "", ""
+		if !reflect.DeepEqual(gotPod, wantPod) {
+			t.Errorf("pod %d = %+v, want %+v", i, gotPod, wantPod)
+		}
+	}
+}
+
+func TestAPIListPods(t *testing.T) {
+	items := recordedPods(t)
+	client := fake.NewSimpleClientset()
+	pages := 0
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pages++
+		list := &corev1.PodList{}
+		if pages == 1 {
+			list.Items = items[:4]
+			list.Continue = "page-2"
+		} else {
+			list.Items = items[4:]
+		}
+		return true, list, nil
+	})
+	backend := &APIBackend{Client: client, Namespace: "default"}
+	pods, err := backend.ListPods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("listed %d pages, want 2", pages)
+	}
+
+	replay(t)
+	want, err := testKubectlBackend().ListPods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePods(t, pods, want)
+}
+
+func TestAPIGetPod(t *testing.T) {
+	items := recordedPods(t)
+	backend := &APIBackend{Client: fake.NewSimpleClientset(&items[1]), Namespace: "default"}
+	pod, err := backend.GetPod(context.Background(), "db-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay(t)
+	want, err := testKubectlBackend().GetPod(context.Background(), "db-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePods(t, []*Pod{pod}, []*Pod{want})
+
+	if _, err := backend.GetPod(context.Background(), "missing"); !apierrors.IsNotFound(err) {
+		t.Errorf("GetPod(missing) error = %v, want not found", err)
+	}
+}
+
+func TestAPIGetService(t *testing.T) {
+	var object corev1.Service
+	recordedOutput(t, "get-service-api.json", &object)
+	backend := &APIBackend{Client: fake.NewSimpleClientset(&object), Namespace: "default"}
+	service, err := backend.GetService(context.Background(), "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay(t)
+	want, err := testKubectlBackend().GetService(context.Background(), "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(service, want) {
+		t.Errorf("GetService(api) = %+v, want %+v", service, want)
+	}
+	if len(service.Ports) != 2 || service.Ports[0].TargetPort != "http" || service.Ports[1].TargetPort != "9090" {
+		t.Errorf("GetService(api) ports = %+v", service.Ports)
+	}
+}
+
+func TestAPIWatchPods(t *testing.T) {
+	items := recordedPods(t)
+	byName := make(map[string]*corev1.Pod)
+	for i := range items {
+		byName[items[i].Name] = &items[i]
+	}
+	watcher := watch.NewFake()
+	client := fake.NewSimpleClientset()
+	client.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+	go func() {
+		watcher.Add(byName["api-7d9f8b6c5-x2x4q"])
+		watcher.Modify(byName["db-0"])
+		watcher.Delete(byName["debug"])
+		watcher.Stop()
+	}()
+	var events []PodEvent
+	backend := &APIBackend{Client: client, Namespace: "default"}
+	if err := backend.WatchPods(context.Background(), func(event PodEvent) {
+		events = append(events, event)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	replay(t)
+	var want []PodEvent
+	if err := testKubectlBackend().WatchPods(context.Background(), func(event PodEvent) {
+		want = append(want, event)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 3 || len(events) != len(want) {
+		t.Fatalf("got %d events, want %d (kubectl saw %d)", len(events), 3, len(want))
+	}
+	for i := range events {
+		if events[i].Type != want[i].Type {
+			t.Errorf("event %d is %s, want %s", i, events[i].Type, want[i].Type)
+		}
+		comparePods(t, []*Pod{events[i].Pod}, []*Pod{want[i].Pod})
+	}
+}
+
+func TestAPIWatchPodsError(t *testing.T) {
+	watcher := watch.NewFake()
+	client := fake.NewSimpleClientset()
+	client.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+	go watcher.Error(&metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    410,
+		Reason:  metav1.StatusReasonExpired,
+		Message: "too old resource version",
+	})
+	backend := &APIBackend{Client: client, Namespace: "default"}
+	err := backend.WatchPods(context.Background(), func(PodEvent) {})
+	if err == nil || !strings.Contains(err.Error(), "too old resource version") {
+		t.Errorf("WatchPods() error = %v, want the watch error", err)
+	}
+}
+
+func TestAPIBackendCache(t *testing.T) {
+	previous := backendString
+	backendString = BackendAPI
+	resetBackends()
+	t.Cleanup(func() {
+		backendString = previous
+		resetBackends()
+	})
+	work := filepath.Join("testdata", "kubeconfig-work.yaml")
+
+	staging := ClusterTarget{Kubeconfig: work, Context: "staging"}
+	backend := backendFor(staging)
+	api, ok := backend.(*APIBackend)
+	if !ok || api.Namespace != "web" {
+		t.Fatalf("backendFor(staging) = %#v, want an API backend for namespace web", backend)
+	}
+	// Terminals opened from a pinned backend stay on its cluster.
+	command := strings.Join(api.ExecCommand("api", "app", []string{"sh"}), " ")
+	if !strings.Contains(command, " exec --kubeconfig="+work+" --context=staging --namespace=web -c app api -- sh") {
+		t.Errorf("ExecCommand() = %q, want the staging target", command)
+	}
+	if backendFor(staging) != backend {
+		t.Error("backendFor(staging) built a second backend")
+	}
+	prod := backendFor(ClusterTarget{Kubeconfig: work, Context: "prod", Namespace: "team-a"})
+	if api, ok := prod.(*APIBackend); !ok || api.Namespace != "team-a" || prod == backend {
+		t.Errorf("backendFor(prod) = %#v, want a separate backend for namespace team-a", prod)
+	}
+
+	missing := ClusterTarget{Kubeconfig: work, Context: "missing"}
+	broken := backendFor(missing)
+	if _, err := broken.ListPods(context.Background()); err == nil {
+		t.Error("backend for a missing context listed pods")
+	}
+	if _, ok := broken.(*APIBackend); ok {
+		t.Error("backend for a missing context is an API backend")
+	}
+	if backendFor(missing) != broken {
+		t.Error("backendFor(missing) was built again")
+	}
+
+	resetBackends()
+	if backendFor(staging) == backend {
+		t.Error("resetBackends() kept the staging backend")
+	}
+}
+
+func TestAPILogsCommand(t *testing.T) {
+	command := (&APIBackend{}).LogsCommand(LogOptions{Pod: "api", Follow: true, Tail: "10", AllContainers: true})
+	args := strings.Join(command[1:], " ")
+	if command[1] != "logs" || !strings.Contains(args, "--all-containers=true") || strings.Contains(args, "--prefix") {
+		t.Errorf("LogsCommand() = %q, want the CLI's logs flags", command)
+	}
+}
@@ -0,0 +1,268 @@
+package kubectl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KubectlBackend implements Backend by running kubectl through the Runner.
+// Target pins its calls to a context and namespace; without one they follow
+// the tray's selection.
+type KubectlBackend struct {
+	Target *ClusterTarget
+}
+
+func (b KubectlBackend) args(args ...string) []string {
+	if b.Target == nil {
+		return kubectlArgs(args...)
+	}
+	global := []string{}
+	if b.Target.Kubeconfig != "" {
+		global = append(global, "--kubeconfig="+b.Target.Kubeconfig)
+	}
+	if b.Target.Context != "" {
+		global = append(global, "--context="+b.Target.Context)
+	}
+	if b.Target.Namespace != "" {
+		global = append(global, "--namespace="+b.Target.Namespace)
+	}
+
+	return append(global, args...)
+}
+
+func (b KubectlBackend) run(ctx context.Context, args ...string) ([]byte, error) {
+	result, err := runner.Run(ctx, Command{
+		Args:    b.args(args...),
+		Timeout: defaultTimeout,
+	})
+
+	return result.Stdout, err
+}
+
+func (b KubectlBackend) CheckConnection(ctx context.Context) error {
+	_, err := b.run(ctx, "cluster-info", "--request-timeout=5s")
+
+	return err
+}
+
+func (b KubectlBackend) ListNamespaces(ctx context.Context) ([]string, error) {
+	rawNamespaces, err := b.run(ctx, "get", "namespaces", "-o", "name")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNamespaces(rawNamespaces), nil
+}
+
+func parseNamespaces(rawNamespaces []byte) []string {
+	namespaces := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(rawNamespaces)))
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		name, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "namespace/")
+		if !ok {
+			continue
+		}
+		namespaces = append(namespaces, name)
+	}
+
+	return namespaces
+}
+
+// podChunkLimit bounds how many pods the API server returns per page when
+// listing very large namespaces. kubectl follows the pages itself and still
+// prints a single list.
+const podChunkLimit = 500
+
+func (b KubectlBackend) ListPods(ctx context.Context) ([]*Pod, error) {
+	rawPods, err := b.run(ctx, "get", "pods", "-o", "json", "--chunk-size="+strconv.Itoa(podChunkLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePodList(rawPods)
+}
+
+func parsePodList(rawPods []byte) ([]*Pod, error) {
+	var list corev1.PodList
+	if err := json.Unmarshal(rawPods, &list); err != nil {
+		return nil, err
+	}
+	pods := []*Pod{}
+	for i := range list.Items {
+		pods = append(pods, newPod(&list.Items[i]))
+	}
+
+	return pods, nil
+}
+
+func (b KubectlBackend) GetPod(ctx context.Context, name string) (*Pod, error) {
+	if err := validatePodName(name); err != nil {
+		return nil, err
+	}
+	rawPod, err := b.run(ctx, "get", "pods", name, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(rawPod, &pod); err != nil {
+		return nil, err
+	}
+
+	return newPod(&pod), nil
+}
+
+func (b KubectlBackend) GetService(ctx context.Context, name string) (*Service, error) {
+	if err := validatePodName(name); err != nil {
+		return nil, err
+	}
+	rawService, err := b.run(ctx, "get", "services", name, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var service corev1.Service
+	if err := json.Unmarshal(rawService, &service); err != nil {
+		return nil, err
+	}
+
+	return newService(&service), nil
+}
+
+func (b KubectlBackend) WatchPods(ctx context.Context, handler func(PodEvent)) error {
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := runner.Run(ctx, Command{
+			Args:   b.args("get", "pods", "--watch", "--output-watch-events", "-o", "json"),
+			Stdout: writer,
+		})
+		if err == nil {
+			err = io.EOF
+		}
+		writer.CloseWithError(err)
+	}()
+	defer reader.Close()
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		err := decoder.Decode(&event)
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Type == "ERROR" {
+			var status metav1.Status
+			json.Unmarshal(event.Object, &status)
+			return errors.New("watch failed: " + status.Message)
+		}
+		var pod corev1.Pod
+		if err := json.Unmarshal(event.Object, &pod); err != nil {
+			return err
+		}
+		handler(PodEvent{
+			Type: event.Type,
+			Pod:  newPod(&pod),
+		})
+	}
+}
+
+func (b KubectlBackend) Exec(ctx context.Context, opts ExecOptions) error {
+	if err := validatePodTarget(opts.Pod, opts.Container); err != nil {
+		return err
+	}
+	args := b.args("exec", "-i")
+	if opts.TTY {
+		args = append(args, "-t")
+	}
+	args = append(args, "-c", opts.Container, opts.Pod, "--")
+	_, err := runner.Run(ctx, Command{
+		Args:   append(args, opts.Command...),
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Stderr: opts.Stderr,
+	})
+
+	return err
+}
+
+func (b KubectlBackend) Logs(ctx context.Context, opts LogOptions, out io.Writer) error {
+	if err := validateLogOptions(opts); err != nil {
+		return err
+	}
+	_, err := runner.Run(ctx, Command{
+		Args:   b.args(logsArgs(opts)...),
+		Stdout: out,
+	})
+
+	return err
+}
+
+func logsArgs(opts LogOptions) []string {
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "-f")
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail="+opts.Tail)
+	}
+	if opts.Timestamps {
+		args = append(args, "--timestamps=true")
+	}
+	if opts.Previous {
+		args = append(args, "--previous=true")
+	}
+	if opts.Since != "" {
+		args = append(args, "--since="+opts.Since)
+	}
+	if opts.SinceTime != "" {
+		args = append(args, "--since-time="+opts.SinceTime)
+	}
+	if opts.AllContainers {
+		return append(args, "--all-containers=true", "--prefix=true", opts.Pod)
+	}
+
+	return append(args, "-c", opts.Container, opts.Pod)
+}
+
+func (b KubectlBackend) PortForward(ctx context.Context, pod string, ports []string) error {
+	if err := validatePodName(pod); err != nil {
+		return err
+	}
+	for _, mapping := range ports {
+		from, to, ok := strings.Cut(mapping, ":")
+		if !ok {
+			from, to = "", mapping
+		}
+		if err := validatePortMapping(from, to); err != nil {
+			return err
+		}
+	}
+	_, err := runner.Run(ctx, Command{
+		Args: b.args(append([]string{"port-forward", pod}, ports...)...),
+	})
+
+	return err
+}
+
+func (b KubectlBackend) ExecCommand(pod, container string, command []string) []string {
+	args := append([]string{"kubectl"}, b.args("exec", "-it", "-c", container, pod, "--")...)
+
+	return append(args, command...)
+}
+
+func (b KubectlBackend) LogsCommand(opts LogOptions) []string {
+	return append([]string{"kubectl"}, b.args(logsArgs(opts)...)...)
+}
@@ -0,0 +1,237 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testTarget = ClusterTarget{Context: "test", Namespace: "default"}
+
+// replay makes every kubectl call in the test replay the recordings in
+// testdata/kubectl and returns the fake to inspect the calls made.
+func replay(t *testing.T) *FakeRunner {
+	t.Helper()
+	fake := NewFakeRunner()
+	if err := fake.Load(filepath.Join("testdata", "kubectl")); err != nil {
+		t.Fatal(err)
+	}
+	previous := runner
+	SetRunner(fake)
+	t.Cleanup(func() {
+		SetRunner(previous)
+	})
+
+	return fake
+}
+
+func testKubectlBackend() KubectlBackend {
+	target := testTarget
+
+	return KubectlBackend{Target: &target}
+}
+
+func TestParseNamespaces(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"names", "namespace/default\nnamespace/kube-system\n", []string{"default", "kube-system"}},
+		{"blank lines and spaces", "\n  namespace/default  \n\nnamespace/team-a", []string{"default", "team-a"}},
+		{"other kinds skipped", "pod/api\nnamespace/default\nNo resources found\n", []string{"default"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseNamespaces([]byte(test.raw)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseNamespaces(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestKubectlListNamespaces(t *testing.T) {
+	fake := replay(t)
+	namespaces, err := testKubectlBackend().ListNamespaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"default", "kube-system", "team-a"}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("ListNamespaces() = %q, want %q", namespaces, want)
+	}
+	want := [][]string{{"--context=test", "--namespace=default", "get", "namespaces", "-o", "name"}}
+	if !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("kubectl calls = %q, want %q", fake.Calls, want)
+	}
+}
+
+func TestKubectlListPods(t *testing.T) {
+	replay(t)
+	pods, err := testKubectlBackend().ListPods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		status     string
+		ready      string
+		restarts   string
+		containers []Container
+	}{
+		{
+			name:     "api-7d9f8b6c5-x2x4q",
+			status:   "CrashLoopBackOff",
+			ready:    "1/2",
+			restarts: "4",
+			containers: []Container{
+				{Name: "api", Image: "api:1.2", Ready: "true"},
+				{Name: "metrics", Image: "exporter:3", Ready: "false"},
+			},
+		},
+		{
+			name:     "db-0",
+			status:   "Running",
+			ready:    "1/1",
+			restarts: "1",
+			containers: []Container{
+				{Name: "postgres", Image: "postgres:16", Ready: "true"},
+			},
+		},
+		{
+			name:       "node-agent-abcde",
+			status:     "ContainerCreating",
+			ready:      "0/1",
+			restarts:   "0",
+			containers: []Container{{Name: "agent", Image: "agent:2", Ready: "false"}},
+		},
+		{
+			name:       "debug",
+			status:     "Completed",
+			ready:      "0/1",
+			restarts:   "0",
+			containers: []Container{{Name: "shell", Image: "busybox", Ready: "false"}},
+		},
+		{
+			name:       "migrate-h7k2p",
+			status:     "Terminating",
+			ready:      "1/1",
+			restarts:   "0",
+			containers: []Container{{Name: "migrate", Image: "api:1.2", Ready: "true"}},
+		},
+		{
+			name:       "legacy-rs-q8w2e",
+			status:     "Evicted",
+			ready:      "0/1",
+			restarts:   "0",
+			containers: []Container{{Name: "legacy", Image: "legacy:1", Ready: "false"}},
+		},
+	}
+	if len(pods) != len(tests) {
+		t.Fatalf("ListPods() returned %d pods, want %d", len(pods), len(tests))
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := pods[i]
+			if pod.Name != test.name {
+				t.Fatalf("pod %d is %s, want %s", i, pod.Name, test.name)
+			}
+			if pod.Status != test.status {
+				t.Errorf("Status = %q, want %q", pod.Status, test.status)
+			}
+			if pod.Ready != test.ready {
+				t.Errorf("Ready = %q, want %q", pod.Ready, test.ready)
+			}
+			if pod.Restarts != test.restarts {
+				t.Errorf("Restarts = %q, want %q", pod.Restarts, test.restarts)
+			}
+			containers := []Container{}
+			for _, container := range pod.Containers {
+				containers = append(containers, Container{Name: container.Name, Image: container.Image, Ready: container.Ready})
+			}
+			if !reflect.DeepEqual(containers, test.containers) {
+				t.Errorf("Containers = %+v, want %+v", containers, test.containers)
+			}
+		})
+	}
+}
+
+func TestKubectlGetPod(t *testing.T) {
+	replay(t)
+	pod, err := testKubectlBackend().GetPod(context.Background(), "db-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Name != "db-0" || pod.Status != "Running" || pod.Ready != "1/1" {
+		t.Errorf("GetPod(db-0) = %+v", pod)
+	}
+	// Tailing selects pods by their labels and phase.
+	if pod.Labels["app"] != "db" || pod.Phase != "Running" {
+		t.Errorf("GetPod(db-0) labels = %v, phase = %q", pod.Labels, pod.Phase)
+	}
+
+	_, err = testKubectlBackend().GetPod(context.Background(), "missing")
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("GetPod(missing) error = %v, want a RunError", err)
+	}
+	if want := `Error from server (NotFound): pods "missing" not found`; runErr.Error() != want {
+		t.Errorf("GetPod(missing) error = %q, want %q", runErr.Error(), want)
+	}
+}
+
+func TestLogsArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts LogOptions
+		want []string
+	}{
+		{
+			name: "container",
+			opts: LogOptions{Pod: "api", Container: "app"},
+			want: []string{"logs", "-c", "app", "api"},
+		},
+		{
+			name: "follow with tail and timestamps",
+			opts: LogOptions{Pod: "api", Container: "app", Follow: true, Tail: "10", Timestamps: true},
+			want: []string{"logs", "-f", "--tail=10", "--timestamps=true", "-c", "app", "api"},
+		},
+		{
+			name: "previous since",
+			opts: LogOptions{Pod: "api", Container: "app", Previous: true, Since: "1h"},
+			want: []string{"logs", "--previous=true", "--since=1h", "-c", "app", "api"},
+		},
+		{
+			name: "since time",
+			opts: LogOptions{Pod: "api", Container: "app", SinceTime: "2024-01-02T15:04:05Z"},
+			want: []string{"logs", "--since-time=2024-01-02T15:04:05Z", "-c", "app", "api"},
+		},
+		{
+			name: "all containers",
+			opts: LogOptions{Pod: "api", Container: "app", AllContainers: true, Tail: "-1"},
+			want: []string{"logs", "--tail=-1", "--all-containers=true", "--prefix=true", "api"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := logsArgs(test.opts); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("logsArgs(%+v) = %q, want %q", test.opts, got, test.want)
+			}
+		})
+	}
+}
+
+func TestKubectlLogs(t *testing.T) {
+	replay(t)
+	var out bytes.Buffer
+	opts := LogOptions{Pod: "api-7d9f8b6c5-x2x4q", Container: "api", Tail: "2", Timestamps: true}
+	if err := testKubectlBackend().Logs(context.Background(), opts, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-01-02T15:04:07Z started\n2024-01-02T15:04:08Z listening on :8080\n"; out.String() != want {
+		t.Errorf("Logs() wrote %q, want %q", out.String(), want)
+	}
+}
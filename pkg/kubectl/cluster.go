@@ -1,13 +1,13 @@
 package kubectl
 
 import (
-	"os/exec"
+	"context"
 
 	"github.com/brettcodling/Kubessh/pkg/notify"
 )
 
 func CheckConnection() bool {
-	_, err := exec.Command("bash", "-c", "kubectl cluster-info --request-timeout='5s'").Output()
+	err := getBackend().CheckConnection(context.Background())
 	if err != nil {
 		notify.Warning("ERROR!", err.Error())
 		return false
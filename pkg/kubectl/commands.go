@@ -0,0 +1,184 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/brettcodling/Kubessh/pkg/database"
+)
+
+// The functions in this file serve callers other than the tray, such as the
+// CLI. They return errors instead of raising notifications.
+
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalid matches errors about input that failed validation, such as a bad
+// port or a local port forwarded twice.
+var ErrInvalid = errors.New("invalid input")
+
+// ErrTargetOverridden is returned when the selection is changed while
+// SetTarget overrides the context or namespace, as the change would not
+// outlive the process.
+var ErrTargetOverridden = errors.New("the context or namespace is overridden for this call only")
+
+// SetTarget overrides the kubeconfig, context and namespace for the current
+// process only. Empty values keep the configured ones.
+func SetTarget(kubeconfig, context, namespace string) error {
+	if context != "" {
+		if err := validateContextName(context); err != nil {
+			return err
+		}
+	}
+	if namespace != "" {
+		if err := validateNamespaceName(namespace); err != nil {
+			return err
+		}
+	}
+	if kubeconfig != "" {
+		activeKubeconfig = kubeconfig
+	}
+	if context != "" || namespace != "" {
+		targetOverride = true
+	}
+	if context != "" {
+		isolated = true
+		sessionContext = context
+		// An empty namespace leaves kubectl on the context's own default.
+		sessionNamespace = namespace
+	} else if namespace != "" {
+		ListContexts()
+		sessionContext = getCurrentContext().Name
+		isolated = true
+		sessionNamespace = namespace
+	}
+
+	return nil
+}
+
+func CurrentContext() *Context {
+	ListContexts()
+
+	return getCurrentContext()
+}
+
+func CurrentNamespace() string {
+	ListContexts()
+
+	return getCurrentNamespace().Name
+}
+
+// UseContext selects the context called name, limited to the file kubeconfig
+// when it is not empty.
+func UseContext(name, kubeconfig string) error {
+	if targetOverride {
+		return ErrTargetOverridden
+	}
+	contexts, err := ListContexts()
+	if err != nil {
+		return err
+	}
+	for _, context := range contexts {
+		if context.Name == name && (kubeconfig == "" || context.File == kubeconfig) {
+			return context.Use()
+		}
+	}
+
+	return fmt.Errorf("context %s %w", name, ErrNotFound)
+}
+
+func ListNamespaces(ctx context.Context) ([]string, error) {
+	return getBackend().ListNamespaces(ctx)
+}
+
+func UseNamespace(name string) error {
+	if targetOverride {
+		return ErrTargetOverridden
+	}
+	ListContexts()
+
+	return Namespace{Name: name}.Use()
+}
+
+func ListPods(ctx context.Context) ([]*Pod, error) {
+	pods, err := getBackend().ListPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+
+	return pods, nil
+}
+
+func StreamLogs(ctx context.Context, opts LogOptions, out io.Writer) error {
+	if err := validateLogOptions(opts); err != nil {
+		return err
+	}
+	if opts.Tail != "" {
+		if err := validateTail(opts.Tail); err != nil {
+			return err
+		}
+	}
+
+	return getBackend().Logs(ctx, opts, out)
+}
+
+// SavedPortForward is the port mappings remembered from the pod window for
+// a target in one context and namespace.
+type SavedPortForward struct {
+	ID       string        `json:"id"`
+	Pod      string        `json:"pod"`
+	Mappings []PortMapping `json:"mappings"`
+	ClusterTarget
+}
+
+func SavedPortForwards() []SavedPortForward {
+	forwards := []SavedPortForward{}
+	for _, value := range database.List("FORWARD-PORTS-") {
+		var saved SavedPortForward
+		if err := json.Unmarshal([]byte(value), &saved); err != nil {
+			log.Println(err)
+			continue
+		}
+		forwards = append(forwards, saved)
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].ID < forwards[j].ID
+	})
+
+	return forwards
+}
+
+// GetSavedPortForward returns the ports saved for pod in the selected
+// context and namespace.
+func GetSavedPortForward(pod string) (SavedPortForward, error) {
+	if saved, ok := getSavedPortForward(ForwardID(pod)); ok {
+		return saved, nil
+	}
+
+	return SavedPortForward{}, fmt.Errorf("saved port forward for %s %w", pod, ErrNotFound)
+}
+
+// Run forwards the saved ports until ctx is done. Pod may also be a target
+// such as svc/api, forwarded to one of its ready pods.
+func (forward SavedPortForward) Run(ctx context.Context) error {
+	if err := validateForwardTarget(forward.Pod); err != nil {
+		return err
+	}
+	if err := validatePortMappings(forward.Mappings); err != nil {
+		return err
+	}
+	target := PortForward{Pod: forward.Pod, Mappings: forward.Mappings, ClusterTarget: forward.ClusterTarget}
+	pod, ports, err := target.resolve(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	return backendFor(forward.ClusterTarget).PortForward(ctx, pod, ports)
+}
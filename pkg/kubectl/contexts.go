@@ -1,14 +1,13 @@
 package kubectl
 
 import (
-	"bufio"
-	"errors"
+	"fmt"
 	"log"
-	"os/exec"
 	"strings"
 
 	"github.com/brettcodling/Kubessh/pkg/notify"
 	"github.com/brettcodling/systray"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type MenuItem struct {
@@ -17,15 +16,20 @@ type MenuItem struct {
 }
 
 type Context struct {
-	Name      string
-	Namespace string
-	InUse     bool
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace"`
+	// File is the kubeconfig file the context was read from.
+	File  string `json:"file"`
+	InUse bool   `json:"current"`
 }
 
 var (
-	Contexts         []*Context
-	contextMenuItems []MenuItem
-	contextsMenuItem *systray.MenuItem
+	Contexts              []*Context
+	contextMenuItems      []MenuItem
+	contextGroupMenuItems []*systray.MenuItem
+	contextsMenuItem      *systray.MenuItem
 )
 
 func AddContexts() {
@@ -34,37 +38,43 @@ func AddContexts() {
 }
 
 func GetContexts() []*Context {
-	Contexts = []*Context{}
-	cmd := "kubectl config get-contexts --no-headers"
-	rawContexts, err := exec.Command("bash", "-c", cmd).Output()
+	_, err := ListContexts()
 	if err != nil {
 		log.Println(err)
 		notify.Warning("ERROR!", err.Error())
+		Contexts = []*Context{}
+	}
 
-		return Contexts
+	return Contexts
+}
+
+func ListContexts() ([]*Context, error) {
+	contexts, err := loadContexts()
+	if err != nil {
+		return nil, err
 	}
-	scanner := bufio.NewScanner(strings.NewReader(string(rawContexts)))
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		var context Context
-		columns := strings.Fields(scanner.Text())
-		if columns[0] == "*" {
-			context.InUse = true
-			columns = columns[1:]
+	if isolated && sessionContext != "" {
+		for _, context := range contexts {
+			context.InUse = context.Name == sessionContext && (activeKubeconfig == "" || context.File == activeKubeconfig)
 		}
-		context.Name = columns[0]
-		if len(columns) == 4 {
-			context.Namespace = columns[3]
-		}
-		Contexts = append(Contexts, &context)
 	}
+	Contexts = contexts
 
-	return Contexts
+	return Contexts, nil
 }
 
 func getCurrentContext() *Context {
-	cmd := "kubectl config current-context"
-	rawCurrentContext, err := exec.Command("bash", "-c", cmd).Output()
+	if isolated && sessionContext != "" {
+		for _, context := range Contexts {
+			if context.Name == sessionContext && (activeKubeconfig == "" || context.File == activeKubeconfig) {
+				return context
+			}
+		}
+
+		return &Context{Name: sessionContext}
+	}
+
+	config, err := loadKubeconfig()
 	if err != nil {
 		log.Println(err)
 		notify.Warning("ERROR!", err.Error())
@@ -72,9 +82,8 @@ func getCurrentContext() *Context {
 		return &Context{}
 	}
 
-	current := strings.TrimSpace(string(rawCurrentContext))
 	for _, context := range Contexts {
-		if context.Name == current {
+		if context.Name == config.CurrentContext && (activeKubeconfig == "" || context.File == activeKubeconfig) {
 			return context
 		}
 	}
@@ -82,13 +91,37 @@ func getCurrentContext() *Context {
 	return &Context{}
 }
 
+func (context Context) tooltip() string {
+	return "Cluster: " + context.Cluster + "\nUser: " + context.User + "\nFile: " + context.File
+}
+
 func SetContexts() {
+	resetBackends()
 	for _, contextMenuItem := range contextMenuItems {
 		contextMenuItem.Item.Remove()
 	}
 	contextMenuItems = []MenuItem{}
-	for _, c := range GetContexts() {
-		context := contextsMenuItem.AddSubMenuItem("", "")
+	for _, group := range contextGroupMenuItems {
+		group.Remove()
+	}
+	contextGroupMenuItems = []*systray.MenuItem{}
+	files, groups := groupContextsByFile(GetContexts())
+	for _, file := range files {
+		parent := contextsMenuItem
+		if len(files) > 1 {
+			parent = contextsMenuItem.AddSubMenuItem(kubeconfigTitle(file), file)
+			contextGroupMenuItems = append(contextGroupMenuItems, parent)
+		}
+		addContextMenuItems(parent, groups[file])
+	}
+
+	contextsMenuItem.SetTitle("Context: " + getCurrentContext().Name)
+	contextsMenuItem.Show()
+}
+
+func addContextMenuItems(parent *systray.MenuItem, contexts []*Context) {
+	for _, c := range contexts {
+		context := parent.AddSubMenuItem("", c.tooltip())
 		item := MenuItem{
 			Item:  context,
 			Title: c.Name,
@@ -104,7 +137,11 @@ func SetContexts() {
 				case <-context.ClickedCh:
 					if !c.InUse {
 						go func(c *Context) {
-							c.Use()
+							if err := c.Use(); err != nil {
+								log.Println(err)
+								notify.Warning("ERROR!", err.Error())
+								return
+							}
 							for _, contextItem := range contextMenuItems {
 								contextItem.Title = strings.TrimLeft(contextItem.Title, "* ")
 								contextItem.Item.SetTitle(contextItem.Title)
@@ -112,43 +149,60 @@ func SetContexts() {
 							context.SetTitle("* " + c.Name)
 							contextsMenuItem.SetTitle("Context: " + c.Name)
 							SetNamespaces()
+							restartPodWatch()
 						}(c)
 					}
 				}
 			}
 		}(c)
 	}
-
-	contextsMenuItem.SetTitle("Context: " + getCurrentContext().Name)
-	contextsMenuItem.Show()
 }
 
 func (context Context) Use() error {
-	if context.Name == getCurrentContext().Name {
+	if err := validateContextName(context.Name); err != nil {
+		return err
+	}
+	current := getCurrentContext()
+	if context.Name == current.Name && context.File == current.File {
 		return nil
 	}
 
-	cmd := "kubectl config use-context \"" + context.Name + "\""
-	rawResult, err := exec.Command("bash", "-c", cmd).CombinedOutput()
-	result := strings.TrimSpace(string(rawResult))
-	if len(result) < 19 || result[0:19] != "Switched to context" {
-		if len(result) >= 5 && result[:5] == "error" {
-			return errors.New(result)
+	previousKubeconfig := activeKubeconfig
+	if len(kubeconfigPaths) > 0 {
+		activeKubeconfig = context.File
+	}
+	if isolated {
+		selectSession(context.Name, context.Namespace)
+	} else {
+		// The kubeconfig is switched first, so that the file holding the
+		// new context is the one edited.
+		err := modifyKubeconfig(func(config *clientcmdapi.Config) error {
+			if _, ok := config.Contexts[context.Name]; !ok {
+				return fmt.Errorf("no context exists with the name %q", context.Name)
+			}
+			config.CurrentContext = context.Name
+			return nil
+		})
+		if err != nil {
+			activeKubeconfig = previousKubeconfig
+			return err
 		}
 	}
-	if err != nil {
-		return err
+	if activeKubeconfig != previousKubeconfig {
+		setActiveKubeconfig(activeKubeconfig)
 	}
 
 	for key, c := range Contexts {
 		if c.InUse {
 			c.InUse = false
 		}
-		if context.Name == c.Name {
+		if context.Name == c.Name && context.File == c.File {
 			c.InUse = true
 		}
 		Contexts[key] = c
 	}
 
+	publishContext()
+
 	return nil
 }
@@ -0,0 +1,107 @@
+package kubectl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestLoadContexts(t *testing.T) {
+	work := filepath.Join("testdata", "kubeconfig-work.yaml")
+	home := filepath.Join("testdata", "kubeconfig-home.yaml")
+	previousPaths, previousActive := kubeconfigPaths, activeKubeconfig
+	t.Cleanup(func() {
+		kubeconfigPaths, activeKubeconfig = previousPaths, previousActive
+	})
+
+	t.Run("registered files", func(t *testing.T) {
+		kubeconfigPaths, activeKubeconfig = []string{work, home}, home
+		contexts, err := loadContexts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*Context{
+			{Name: "arn:aws:eks:eu-west-1:123456789012:cluster/shared", Cluster: "prod-cluster", User: "alice", Namespace: "kube-system", File: work},
+			{Name: "prod", Cluster: "prod-cluster", User: "alice", File: work},
+			// staging is current in its own file, but that file is not
+			// the active one.
+			{Name: "staging", Cluster: "staging-cluster", User: "alice", Namespace: "web", File: work},
+			{Name: "kind-dev", Cluster: "kind-dev", User: "kind-dev", File: home, InUse: true},
+		}
+		if !reflect.DeepEqual(contexts, want) {
+			t.Errorf("loadContexts() = %+v, want %+v", contexts, want)
+		}
+		files, groups := groupContextsByFile(contexts)
+		if !reflect.DeepEqual(files, []string{work, home}) || len(groups[work]) != 3 || len(groups[home]) != 1 {
+			t.Errorf("groupContextsByFile() = %q, %v", files, groups)
+		}
+	})
+
+	t.Run("default lookup", func(t *testing.T) {
+		kubeconfigPaths, activeKubeconfig = nil, ""
+		t.Setenv("KUBECONFIG", work)
+		contexts, err := loadContexts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		current := []string{}
+		for _, context := range contexts {
+			if context.InUse {
+				current = append(current, context.Name)
+			}
+		}
+		if len(contexts) != 3 || !reflect.DeepEqual(current, []string{"staging"}) {
+			t.Errorf("loadContexts() = %+v, want 3 contexts with staging current", contexts)
+		}
+	})
+}
+
+// Switching context or namespace edits the kubeconfig without kubectl.
+func TestUseEditsKubeconfig(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "kubeconfig-work.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	previousPaths, previousActive, previousIsolated := kubeconfigPaths, activeKubeconfig, isolated
+	previousContexts, previousNamespaces := Contexts, Namespaces
+	kubeconfigPaths, activeKubeconfig, isolated = nil, "", false
+	t.Cleanup(func() {
+		kubeconfigPaths, activeKubeconfig, isolated = previousPaths, previousActive, previousIsolated
+		Contexts, Namespaces = previousContexts, previousNamespaces
+	})
+	t.Setenv("KUBECONFIG", path)
+	fake := NewFakeRunner()
+	previousRunner := runner
+	SetRunner(fake)
+	t.Cleanup(func() {
+		SetRunner(previousRunner)
+	})
+
+	GetContexts()
+	if err := (Context{Name: "prod", File: path}).Use(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Namespace{Name: "team-a"}).Use(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Context{Name: "missing", File: path}).Use(); err == nil {
+		t.Error("Use() of a missing context succeeded")
+	}
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentContext != "prod" || config.Contexts["prod"].Namespace != "team-a" || config.Contexts["staging"].Namespace != "web" {
+		t.Errorf("kubeconfig current-context = %q, contexts = %+v", config.CurrentContext, config.Contexts)
+	}
+	if len(fake.Calls) != 0 {
+		t.Errorf("kubectl was run with %q", fake.Calls)
+	}
+}
@@ -0,0 +1,63 @@
+package kubectl
+
+import (
+	"sync"
+)
+
+const (
+	EventContext            = "context"
+	EventPortForwardStarted = "port-forward-started"
+	EventPortForwardStopped = "port-forward-stopped"
+)
+
+// Event reports a change in the tray's state to subscribers such as the
+// control socket.
+type Event struct {
+	Type      string `json:"type"`
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = make(map[chan Event]struct{})
+)
+
+// Subscribe returns a channel receiving every Event from now on, and a func
+// to stop receiving them. Events are dropped for subscribers that fall behind.
+func Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, 16)
+	subscribersMu.Lock()
+	subscribers[events] = struct{}{}
+	subscribersMu.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			subscribersMu.Lock()
+			delete(subscribers, events)
+			subscribersMu.Unlock()
+			close(events)
+		})
+	}
+}
+
+func publish(event Event) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for events := range subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+func publishContext() {
+	publish(Event{
+		Type:      EventContext,
+		Context:   getCurrentContext().Name,
+		Namespace: getCurrentNamespace().Name,
+	})
+}
@@ -0,0 +1,127 @@
+package kubectl
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Recording is the stored output of one kubectl invocation.
+type Recording struct {
+	Args   []string `json:"args"`
+	Stdout string   `json:"stdout"`
+	Stderr string   `json:"stderr"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// FakeRunner replays recorded kubectl output instead of running kubectl.
+type FakeRunner struct {
+	mu         sync.Mutex
+	recordings map[string]Recording
+	Calls      [][]string
+}
+
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		recordings: make(map[string]Recording),
+	}
+}
+
+// Record registers the output replayed for the given arguments.
+func (f *FakeRunner) Record(recording Recording) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recordings[recordingKey(recording.Args)] = recording
+}
+
+// Load reads every recording written by a RecordingRunner into dir.
+func (f *FakeRunner) Load(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var recording Recording
+		if err := json.Unmarshal(raw, &recording); err != nil {
+			return err
+		}
+		f.Record(recording)
+	}
+
+	return nil
+}
+
+func (f *FakeRunner) Run(ctx context.Context, cmd Command) (Result, error) {
+	f.mu.Lock()
+	f.Calls = append(f.Calls, cmd.Args)
+	recording, ok := f.recordings[recordingKey(cmd.Args)]
+	f.mu.Unlock()
+	if !ok {
+		return Result{}, &RunError{
+			Args: cmd.Args,
+			Err:  errors.New("no recorded output for kubectl " + strings.Join(cmd.Args, " ")),
+		}
+	}
+
+	result := Result{
+		Stdout: []byte(recording.Stdout),
+		Stderr: []byte(recording.Stderr),
+	}
+	if cmd.Stdout != nil {
+		cmd.Stdout.Write(result.Stdout)
+		result.Stdout = nil
+	}
+	if cmd.Stderr != nil {
+		cmd.Stderr.Write(result.Stderr)
+	}
+	if recording.Error != "" {
+		return result, &RunError{
+			Args:   cmd.Args,
+			Stderr: strings.TrimSpace(recording.Stderr),
+			Err:    errors.New(recording.Error),
+		}
+	}
+
+	return result, nil
+}
+
+// RecordingRunner wraps another Runner and writes every invocation it makes
+// into Dir so that it can be replayed later with a FakeRunner.
+type RecordingRunner struct {
+	Runner Runner
+	Dir    string
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, cmd Command) (Result, error) {
+	result, err := r.Runner.Run(ctx, cmd)
+	recording := Recording{
+		Args:   cmd.Args,
+		Stdout: string(result.Stdout),
+		Stderr: string(result.Stderr),
+	}
+	if err != nil {
+		recording.Error = err.Error()
+	}
+	raw, jsonErr := json.MarshalIndent(recording, "", "  ")
+	if jsonErr == nil && os.MkdirAll(r.Dir, 0700) == nil {
+		os.WriteFile(filepath.Join(r.Dir, recordingKey(cmd.Args)+".json"), raw, 0600)
+	}
+
+	return result, err
+}
+
+func recordingKey(args []string) string {
+	sum := sha1.Sum([]byte(strings.Join(args, "\x00")))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRecordingReplay(t *testing.T) {
+	live := NewFakeRunner()
+	live.Record(Recording{Args: []string{"get", "namespaces", "-o", "name"}, Stdout: "namespace/default\n"})
+	live.Record(Recording{Args: []string{"get", "pods", "gone"}, Stderr: "not found\n", Error: "exit status 1"})
+	dir := t.TempDir()
+	recorder := &RecordingRunner{Runner: live, Dir: dir}
+	for _, args := range [][]string{{"get", "namespaces", "-o", "name"}, {"get", "pods", "gone"}} {
+		recorder.Run(context.Background(), Command{Args: args})
+	}
+
+	replayed := NewFakeRunner()
+	if err := replayed.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	result, err := replayed.Run(context.Background(), Command{Args: []string{"get", "namespaces", "-o", "name"}})
+	if err != nil || string(result.Stdout) != "namespace/default\n" {
+		t.Errorf("replayed namespaces = %q, %v", result.Stdout, err)
+	}
+
+	var stdout bytes.Buffer
+	_, err = replayed.Run(context.Background(), Command{Args: []string{"get", "pods", "gone"}, Stdout: &stdout})
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Error() != "not found" {
+		t.Errorf("replayed error = %v, want RunError not found", err)
+	}
+
+	if _, err := replayed.Run(context.Background(), Command{Args: []string{"version"}}); err == nil {
+		t.Error("unrecorded command succeeded")
+	}
+}
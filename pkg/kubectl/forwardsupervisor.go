@@ -0,0 +1,171 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/brettcodling/Kubessh/pkg/notify"
+)
+
+// The states a tray port-forward can be in, shown in its menu title.
+const (
+	ForwardUp           = "up"
+	ForwardReconnecting = "reconnecting"
+	ForwardFailed       = "failed"
+)
+
+const (
+	// forwardMaxAttempts is how many reconnects in a row may fail before a
+	// forward is given up on.
+	forwardMaxAttempts = 8
+	forwardMaxBackoff  = 30 * time.Second
+	// A forward that stayed up this long starts over with a short backoff
+	// the next time it drops.
+	forwardStableAfter = 10 * time.Second
+)
+
+// forwardTitle names forward in the tray, with its context when that is not
+// the selected one.
+func forwardTitle(forward PortForward) string {
+	title := forward.Pod
+	if forward.Namespace != "" {
+		title = forward.Namespace + "/" + title
+	}
+	if forward.Context != "" && forward.Context != getCurrentContext().Name {
+		title = forward.Context + "/" + title
+	}
+	if forward.Current != "" && forward.Current != forward.Pod {
+		title += " via " + forward.Current
+	}
+	if forward.State != "" {
+		title += " (" + forward.State + ")"
+	}
+
+	return title
+}
+
+// updateForward applies change to the running forward id and refreshes its
+// menu title and saved copy.
+func updateForward(id string, change func(*PortForward)) {
+	portForwardMu.Lock()
+	defer portForwardMu.Unlock()
+	forward, ok := portForwardPorts[id]
+	if !ok {
+		return
+	}
+	change(&forward)
+	portForwardPorts[id] = forward
+	item := portForwarding[id]
+	item.Title = forwardTitle(forward)
+	item.Item.SetTitle(item.Title)
+	portForwarding[id] = item
+	saveActivePortForward(forward)
+}
+
+// retitleForwards refreshes the titles of the running forwards, which name
+// their context only when it isn't the selected one.
+func retitleForwards() {
+	portForwardMu.Lock()
+	defer portForwardMu.Unlock()
+	for id, item := range portForwarding {
+		item.Title = forwardTitle(portForwardPorts[id])
+		item.Item.SetTitle(item.Title)
+		portForwarding[id] = item
+	}
+}
+
+// superviseForward keeps forward running until ctx is done. When the
+// port-forward exits it retries with backoff, resolving the target again so
+// that a replaced pod is swapped for a ready one, and gives up after
+// forwardMaxAttempts.
+func superviseForward(ctx context.Context, forward PortForward) {
+	id, name := forward.ID, forward.Pod
+	backend := backendFor(forward.ClusterTarget)
+	current := forward.Current
+	if forward.Workload == "" && !strings.Contains(name, "/") {
+		if p, err := backend.GetPod(ctx, name); err == nil {
+			forward.Workload = p.Workload
+		}
+	}
+	backoff := time.Second
+	failures := 0
+	for {
+		pod, ports, err := forward.resolve(ctx, current)
+		if err == nil {
+			current = pod
+			updateForward(id, func(f *PortForward) {
+				f.Workload, f.Current, f.State = forward.Workload, current, ForwardUp
+			})
+			started := time.Now()
+			err = backend.PortForward(ctx, pod, ports)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = errors.New("port-forward to " + pod + " exited")
+			}
+			if time.Since(started) >= forwardStableAfter {
+				backoff, failures = time.Second, 0
+			}
+		}
+		log.Println(err)
+		failures++
+		if failures > forwardMaxAttempts {
+			updateForward(id, func(f *PortForward) {
+				f.State = ForwardFailed
+			})
+			notify.Warning("ERROR!", "Port forward for "+name+" failed: "+err.Error())
+			return
+		}
+		updateForward(id, func(f *PortForward) {
+			f.State = ForwardReconnecting
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, forwardMaxBackoff)
+	}
+}
+
+// resolveForwardPod returns current while it is ready, and otherwise the
+// newest ready pod of workload.
+func resolveForwardPod(ctx context.Context, backend Backend, current, workload string) (string, error) {
+	err := fmt.Errorf("no ready pod of %s", workload)
+	if current != "" {
+		pod, getErr := backend.GetPod(ctx, current)
+		if getErr == nil && podReady(pod) {
+			return current, nil
+		}
+		err = getErr
+		if err == nil {
+			err = fmt.Errorf("pod %s is not ready", current)
+		}
+	}
+	if workload == "" || strings.HasPrefix(workload, "pod/") {
+		return "", err
+	}
+	pods, err := backend.ListPods(ctx)
+	if err != nil {
+		return "", err
+	}
+	newest := newestReadyPod(pods, func(pod *Pod) bool {
+		return pod.Workload == workload
+	})
+	if newest == nil {
+		return "", fmt.Errorf("no ready pod of %s", workload)
+	}
+
+	return newest.Name, nil
+}
+
+func podReady(pod *Pod) bool {
+	ready, total, ok := strings.Cut(pod.Ready, "/")
+
+	return ok && pod.Status == "Running" && ready == total && total != "0"
+}
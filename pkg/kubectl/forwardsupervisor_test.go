@@ -0,0 +1,25 @@
+package kubectl
+
+import "testing"
+
+func TestForwardTitle(t *testing.T) {
+	previousIsolated, previousContext := isolated, sessionContext
+	isolated, sessionContext = true, "staging"
+	t.Cleanup(func() {
+		isolated, sessionContext = previousIsolated, previousContext
+	})
+
+	tests := []struct {
+		forward PortForward
+		want    string
+	}{
+		{PortForward{Pod: "svc/api", ClusterTarget: ClusterTarget{Context: "staging", Namespace: "web"}}, "web/svc/api"},
+		{PortForward{Pod: "svc/api", ClusterTarget: ClusterTarget{Context: "prod", Namespace: "web"}}, "prod/web/svc/api"},
+		{PortForward{Pod: "deploy/api", Current: "api-1", State: "reconnecting", ClusterTarget: ClusterTarget{Context: "prod", Namespace: "web"}}, "prod/web/deploy/api via api-1 (reconnecting)"},
+	}
+	for _, test := range tests {
+		if got := forwardTitle(test.forward); got != test.want {
+			t.Errorf("forwardTitle(%+v) = %q, want %q", test.forward, got, test.want)
+		}
+	}
+}
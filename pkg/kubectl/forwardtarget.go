@@ -0,0 +1,460 @@
+package kubectl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/style"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Port-forwards go to a pod by name, or to a stable target such as svc/api
+// or deploy/api that is resolved to one of its ready pods on every connect.
+// Saved ports are keyed by the target, so those of a service or workload
+// survive rollouts.
+
+type Service struct {
+	Name     string            `json:"name"`
+	Selector map[string]string `json:"selector,omitempty"`
+	Ports    []ServicePort     `json:"ports"`
+}
+
+// ServicePort is a port of a Service. TargetPort is the number or name of the
+// container port it sends traffic to, empty when it is the same as Port.
+type ServicePort struct {
+	Name       string `json:"name,omitempty"`
+	Port       int32  `json:"port"`
+	TargetPort string `json:"targetPort,omitempty"`
+	Protocol   string `json:"protocol"`
+}
+
+// forwardPort is a remote port offered in the port-forward UI.
+type forwardPort struct {
+	Label string
+	Port  string
+}
+
+var (
+	podForwardForm    = newPortForwardForm()
+	targetForwardForm = newPortForwardForm()
+	currentOpenTarget nucular.MasterWindow
+
+	podForwardTargets     []string
+	selectedForwardTarget int
+	forwardTargetEditor   nucular.TextEditor
+)
+
+func init() {
+	forwardTargetEditor.Flags = nucular.EditField
+	forwardTargetEditor.SingleLine = true
+}
+
+func newService(s *corev1.Service) *Service {
+	service := &Service{
+		Name:     s.Name,
+		Selector: s.Spec.Selector,
+	}
+	for _, port := range s.Spec.Ports {
+		targetPort := port.TargetPort.String()
+		if port.TargetPort == (intstr.IntOrString{}) {
+			targetPort = ""
+		}
+		service.Ports = append(service.Ports, ServicePort{
+			Name:       port.Name,
+			Port:       port.Port,
+			TargetPort: targetPort,
+			Protocol:   string(port.Protocol),
+		})
+	}
+
+	return service
+}
+
+// forwardKind returns the short name of a kind that can be forwarded to,
+// the one Pod.Workload uses for workloads, such as deploy for deployment.
+func forwardKind(kind string) (string, bool) {
+	switch kind {
+	case "pod", "pods", "po":
+		return "pod", true
+	case "svc", "service", "services":
+		return "svc", true
+	case "deploy", "deployment", "deployments":
+		return "deploy", true
+	case "sts", "statefulset", "statefulsets":
+		return "sts", true
+	case "ds", "daemonset", "daemonsets":
+		return "ds", true
+	case "rs", "replicaset", "replicasets":
+		return "rs", true
+	case "job", "jobs":
+		return "job", true
+	}
+
+	return "", false
+}
+
+func validateForwardTarget(target string) error {
+	kind, name, ok := strings.Cut(target, "/")
+	if !ok {
+		return validatePodName(target)
+	}
+	if _, ok := forwardKind(kind); !ok {
+		return fmt.Errorf("invalid port forward target %q: must be a pod or kind/name where kind is pod, svc, deploy, sts, ds, rs or job", target)
+	}
+	if err := validatePodName(name); err != nil {
+		return fmt.Errorf("invalid port forward target %q: %w", target, err)
+	}
+
+	return nil
+}
+
+// resolve returns the pod to forward to and the LOCAL:REMOTE ports for it.
+// current is kept while it is still a ready pod of the target.
+func (forward PortForward) resolve(ctx context.Context, current string) (string, []string, error) {
+	backend := backendFor(forward.ClusterTarget)
+	kind, name, ok := strings.Cut(forward.Pod, "/")
+	kind, _ = forwardKind(kind)
+	if ok && kind == "svc" {
+		return resolveServicePorts(ctx, backend, name, current, forward.Mappings)
+	}
+	var (
+		pod string
+		err error
+	)
+	switch {
+	case !ok:
+		if current == "" {
+			current = forward.Pod
+		}
+		pod, err = resolveForwardPod(ctx, backend, current, forward.Workload)
+	case kind == "pod":
+		if current == "" {
+			current = name
+		}
+		pod, err = resolveForwardPod(ctx, backend, current, "")
+	default:
+		pod, err = resolveForwardPod(ctx, backend, current, kind+"/"+name)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	ports := []string{}
+	for _, mapping := range forward.Mappings {
+		ports = append(ports, mapping.String())
+	}
+
+	return pod, ports, nil
+}
+
+// resolveServicePorts picks a ready pod selected by the service and maps
+// each service port to the pod's container port.
+func resolveServicePorts(ctx context.Context, backend Backend, name, current string, mappings []PortMapping) (string, []string, error) {
+	service, err := backend.GetService(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(service.Selector) == 0 {
+		return "", nil, fmt.Errorf("service %s has no selector to find its pods", name)
+	}
+	servicePorts := []ServicePort{}
+	for _, mapping := range mappings {
+		found := false
+		for _, port := range service.Ports {
+			if strconv.Itoa(int(port.Port)) == mapping.To {
+				servicePorts = append(servicePorts, port)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", nil, fmt.Errorf("service %s has no port %s", name, mapping.To)
+		}
+	}
+	pods, err := backend.ListPods(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	selector := labels.SelectorFromSet(service.Selector)
+	matches := func(pod *Pod) bool {
+		return selector.Matches(labels.Set(pod.Labels))
+	}
+	pod := newestReadyPod(pods, func(pod *Pod) bool {
+		return pod.Name == current && matches(pod)
+	})
+	if pod == nil {
+		pod = newestReadyPod(pods, matches)
+	}
+	if pod == nil {
+		return "", nil, fmt.Errorf("no ready pod of service %s", name)
+	}
+	ports := []string{}
+	for i, mapping := range mappings {
+		port, err := containerPortFor(pod, servicePorts[i])
+		if err != nil {
+			return "", nil, err
+		}
+		ports = append(ports, mapping.From+":"+port)
+	}
+
+	return pod.Name, ports, nil
+}
+
+// containerPortFor returns the number of the pod's port the service port
+// sends traffic to.
+func containerPortFor(pod *Pod, servicePort ServicePort) (string, error) {
+	targetPort := servicePort.TargetPort
+	if targetPort == "" {
+		return strconv.Itoa(int(servicePort.Port)), nil
+	}
+	if _, err := strconv.Atoi(targetPort); err == nil {
+		return targetPort, nil
+	}
+	for _, container := range pod.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == targetPort {
+				return strconv.Itoa(int(containerPort.Port)), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("pod %s has no port named %s", pod.Name, targetPort)
+}
+
+func newestReadyPod(pods []*Pod, matches func(*Pod) bool) *Pod {
+	var newest *Pod
+	for _, pod := range pods {
+		if matches(pod) && podReady(pod) && (newest == nil || pod.CreatedAt.After(newest.CreatedAt)) {
+			newest = pod
+		}
+	}
+
+	return newest
+}
+
+// forwardPorts lists the remote ports target offers: the ports of a service,
+// or the container ports of a pod.
+func forwardPorts(ctx context.Context, target string) ([]forwardPort, error) {
+	kind, name, ok := strings.Cut(target, "/")
+	if kind, _ := forwardKind(kind); ok && kind == "svc" {
+		service, err := getBackend().GetService(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		ports := []forwardPort{}
+		for _, port := range service.Ports {
+			label := strconv.Itoa(int(port.Port)) + "/" + port.Protocol
+			if port.Name != "" {
+				label = port.Name + " " + label
+			}
+			if port.TargetPort != "" {
+				label += " to " + port.TargetPort
+			}
+			ports = append(ports, forwardPort{Label: label, Port: strconv.Itoa(int(port.Port))})
+		}
+		return ports, nil
+	}
+	podName, _, err := PortForward{Pod: target}.resolve(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	pod, err := getBackend().GetPod(ctx, podName)
+	if err != nil {
+		return nil, err
+	}
+
+	return containerForwardPorts(pod), nil
+}
+
+func containerForwardPorts(pod *Pod) []forwardPort {
+	ports := []forwardPort{}
+	for _, container := range pod.Containers {
+		for _, port := range container.Ports {
+			label := container.Name + ": " + strconv.Itoa(int(port.Port)) + "/" + port.Protocol
+			if port.Name != "" {
+				label = container.Name + ": " + port.Name + " " + strconv.Itoa(int(port.Port)) + "/" + port.Protocol
+			}
+			ports = append(ports, forwardPort{Label: label, Port: strconv.Itoa(int(port.Port))})
+		}
+	}
+
+	return ports
+}
+
+// portForwardForm edits and starts the saved port-forward of one target.
+type portForwardForm struct {
+	saved    SavedPortForward
+	from, to nucular.TextEditor
+	ports    []forwardPort
+}
+
+func newPortForwardForm() *portForwardForm {
+	form := &portForwardForm{}
+	for _, editor := range []*nucular.TextEditor{&form.from, &form.to} {
+		editor.Flags = nucular.EditField
+		editor.SingleLine = true
+	}
+
+	return form
+}
+
+// load fills the form with the mappings saved for target in the selected
+// context and namespace. With nothing saved and a single port to choose
+// from, that port is offered as the first mapping, to the same local port.
+func (form *portForwardForm) load(target string, ports []forwardPort) {
+	cluster := currentClusterTarget()
+	id := forwardID(cluster, target)
+	saved, ok := getSavedPortForward(id)
+	if !ok {
+		saved = SavedPortForward{ID: id, Pod: target, ClusterTarget: cluster}
+	}
+	form.saved = saved
+	form.ports = ports
+	port := ""
+	if len(saved.Mappings) == 0 && len(ports) == 1 {
+		port = ports[0].Port
+	}
+	form.from.SelectAll()
+	form.from.Text([]rune(port))
+	form.to.SelectAll()
+	form.to.Text([]rune(port))
+}
+
+// setMappings saves mappings and stops the running forward, which has to be
+// started again to pick them up.
+func (form *portForwardForm) setMappings(mappings []PortMapping) {
+	saved := form.saved
+	saved.Mappings = mappings
+	if err := savePortForward(saved); err != nil {
+		log.Println(err)
+		notify.Warning("ERROR!", err.Error())
+		return
+	}
+	form.saved = saved
+	cancelPortForwarding(saved.ID)
+}
+
+func (form *portForwardForm) update(w *nucular.Window) {
+	for _, port := range form.ports {
+		w.Row(30).Ratio(0.8, 0.2)
+		w.Label(port.Label, "LC")
+		if w.ButtonText("Use") {
+			form.to.SelectAll()
+			form.to.Text([]rune(port.Port))
+			if len(form.from.Buffer) == 0 {
+				form.from.Text([]rune(port.Port))
+			}
+		}
+	}
+	for i, mapping := range form.saved.Mappings {
+		w.Row(30).Ratio(0.8, 0.2)
+		w.Label(mapping.describe(), "LC")
+		if w.ButtonText("Remove") {
+			mappings := append(append([]PortMapping{}, form.saved.Mappings[:i]...), form.saved.Mappings[i+1:]...)
+			form.setMappings(mappings)
+			break
+		}
+	}
+	w.Row(30).Ratio(0.15, 0.3, 0.15, 0.2, 0.2)
+	w.Label("From:", "LC")
+	form.from.Edit(w)
+	w.Label("To:", "LC")
+	form.to.Edit(w)
+	if w.ButtonText("Add") {
+		mapping := PortMapping{
+			From: strings.TrimSpace(string(form.from.Buffer)),
+			To:   strings.TrimSpace(string(form.to.Buffer)),
+		}
+		mappings := append(append([]PortMapping{}, form.saved.Mappings...), mapping)
+		if err := validatePortMappings(mappings); err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", err.Error())
+		} else {
+			form.setMappings(mappings)
+			form.from.SelectAll()
+			form.from.Text([]rune{})
+			form.to.SelectAll()
+			form.to.Text([]rune{})
+		}
+	}
+	w.Row(30).Dynamic(2)
+	w.Spacing(1)
+	if isPortForwarding(form.saved.ID) {
+		if w.ButtonText("Stop") {
+			cancelPortForwarding(form.saved.ID)
+		}
+	} else {
+		if w.ButtonText("Start") {
+			err := startForward(PortForward{
+				Pod:           form.saved.Pod,
+				Mappings:      form.saved.Mappings,
+				ClusterTarget: form.saved.ClusterTarget,
+			})
+			if err != nil {
+				log.Println(err)
+				notify.Warning("ERROR!", err.Error())
+			}
+		}
+	}
+}
+
+// loadPodForwardForm offers the pod and, when it has one, its workload as
+// port-forward targets. The workload is preferred unless ports are already
+// saved for the pod.
+func loadPodForwardForm(pod Pod) {
+	podForwardTargets = []string{pod.Name}
+	selectedForwardTarget = 0
+	if pod.Workload != "" && !strings.HasPrefix(pod.Workload, "pod/") {
+		podForwardTargets = append(podForwardTargets, pod.Workload)
+		if _, ok := getSavedPortForward(ForwardID(pod.Name)); !ok {
+			selectedForwardTarget = 1
+		}
+	}
+	podForwardForm.load(podForwardTargets[selectedForwardTarget], containerForwardPorts(&pod))
+}
+
+func updatePodPortForward(w *nucular.Window) {
+	if len(podForwardTargets) > 1 {
+		w.Row(30).Dynamic(2)
+		w.Label("Forward to:", "LC")
+		selected := w.ComboSimple(podForwardTargets, selectedForwardTarget, 20)
+		if selected != selectedForwardTarget {
+			selectedForwardTarget = selected
+			podForwardForm.load(podForwardTargets[selected], podForwardForm.ports)
+		}
+	}
+	podForwardForm.update(w)
+}
+
+// OpenForwardTarget opens a port-forward window for target, such as
+// svc/api or deploy/api.
+func OpenForwardTarget(target string) error {
+	if err := validateForwardTarget(target); err != nil {
+		return err
+	}
+	ports, err := forwardPorts(context.Background(), target)
+	if err != nil {
+		return err
+	}
+	if currentOpenTarget != nil {
+		currentOpenTarget.Close()
+	}
+	targetForwardForm.load(target, ports)
+	currentOpenTarget = nucular.NewMasterWindow(0, "Port Forward: "+target, updateForwardTarget)
+	currentOpenTarget.SetStyle(style.FromTheme(style.DarkTheme, 2.0))
+	currentOpenTarget.Main()
+
+	return nil
+}
+
+func updateForwardTarget(w *nucular.Window) {
+	w.Row(40).Dynamic(1)
+	w.Label(targetForwardForm.saved.Pod, "LC")
+	targetForwardForm.update(w)
+}
@@ -0,0 +1,80 @@
+package kubectl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestValidateForwardTarget(t *testing.T) {
+	for _, target := range []string{"api", "pod/api", "svc/api", "service/api", "deploy/api", "deployment/api", "statefulset/db", "ds/node-agent", "replicasets/legacy-rs", "job/migrate"} {
+		if err := validateForwardTarget(target); err != nil {
+			t.Errorf("validateForwardTarget(%q) = %v", target, err)
+		}
+	}
+	for _, target := range []string{"/api", "cronjob/nightly", "configmap/api", "Deploy/api", "deploy/", "deploy/$(id)"} {
+		if err := validateForwardTarget(target); err == nil {
+			t.Errorf("validateForwardTarget(%q) accepted", target)
+		}
+	}
+}
+
+func TestForwardIDAliases(t *testing.T) {
+	for target, want := range map[string]string{
+		"api":              "test/default/pod/api",
+		"pods/api":         "test/default/pod/api",
+		"services/api":     "test/default/svc/api",
+		"deployment/api":   "test/default/deploy/api",
+		"statefulset/db":   "test/default/sts/db",
+		"daemonsets/agent": "test/default/ds/agent",
+	} {
+		if got := forwardID(testTarget, target); got != want {
+			t.Errorf("forwardID(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+// A workload named by its long kind resolves to its pods the same as by its
+// short one.
+func TestResolveWorkloadAlias(t *testing.T) {
+	replay(t)
+	for _, target := range []string{"sts/db", "statefulset/db", "statefulsets/db"} {
+		forward := PortForward{Pod: target, Mappings: []PortMapping{{"5432", "5432"}}, ClusterTarget: testTarget}
+		pod, ports, err := forward.resolve(context.Background(), "")
+		if err != nil || pod != "db-0" || len(ports) != 1 || ports[0] != "5432:5432" {
+			t.Errorf("resolve(%q) = %q, %q, %v, want db-0", target, pod, ports, err)
+		}
+	}
+}
+
+// Workload targets are matched against the workload recorded on each pod,
+// and the pod's container ports are offered for forwarding.
+func TestKubectlPodWorkloads(t *testing.T) {
+	replay(t)
+	pods, err := testKubectlBackend().ListPods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	workloads := []string{}
+	for _, pod := range pods {
+		workloads = append(workloads, pod.Workload)
+	}
+	want := []string{"deploy/api", "sts/db", "ds/node-agent", "pod/debug", "job/migrate", "rs/legacy-rs"}
+	if !reflect.DeepEqual(workloads, want) {
+		t.Errorf("workloads = %q, want %q", workloads, want)
+	}
+	if len(pods) < 2 {
+		t.Fatalf("ListPods() returned %d pods", len(pods))
+	}
+	ports := []ContainerPort{}
+	for _, container := range pods[0].Containers {
+		ports = append(ports, container.Ports...)
+	}
+	wantPorts := []ContainerPort{{Name: "http", Port: 8080, Protocol: "TCP"}, {Port: 9090, Protocol: "TCP"}}
+	if !reflect.DeepEqual(ports, wantPorts) {
+		t.Errorf("api ports = %+v, want %+v", ports, wantPorts)
+	}
+	if got := containerForwardPorts(pods[1]); len(got) != 1 || got[0].Port != "5432" || got[0].Label != "postgres: pg 5432/TCP" {
+		t.Errorf("containerForwardPorts(db-0) = %+v", got)
+	}
+}
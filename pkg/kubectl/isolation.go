@@ -0,0 +1,72 @@
+package kubectl
+
+import (
+	"log"
+
+	"github.com/brettcodling/Kubessh/pkg/database"
+)
+
+// In isolated mode Kubessh keeps its own selected context and namespace and
+// passes them to every kubectl call instead of changing the kubeconfig, so
+// terminals outside Kubessh are never switched to another cluster.
+var (
+	isolated         bool
+	sessionContext   string
+	sessionNamespace string
+	// targetOverride is set when the session was chosen for this process
+	// only, by the CLI's --context or --namespace, and must not be saved.
+	targetOverride bool
+)
+
+func init() {
+	isolated = database.Get("ISOLATED") == "1"
+	sessionContext = database.Get("SESSION_CONTEXT")
+	sessionNamespace = database.Get("SESSION_NAMESPACE")
+}
+
+func setIsolated(enabled bool) {
+	if enabled && !isolated && sessionContext == "" {
+		if config, err := loadKubeconfig(); err == nil {
+			namespace := ""
+			if context, ok := config.Contexts[config.CurrentContext]; ok {
+				namespace = context.Namespace
+			}
+			selectSession(config.CurrentContext, namespace)
+		} else {
+			log.Println(err)
+		}
+	}
+	isolated = enabled
+	value := ""
+	if enabled {
+		value = "1"
+	}
+	database.Set("ISOLATED", value)
+}
+
+func selectSession(context, namespace string) {
+	sessionContext = context
+	sessionNamespace = namespace
+	if targetOverride {
+		return
+	}
+	database.Set("SESSION_CONTEXT", context)
+	database.Set("SESSION_NAMESPACE", namespace)
+}
+
+// targetArgs are the flags selecting the isolated session's context and
+// namespace. They are empty outside isolated mode.
+func targetArgs() []string {
+	if !isolated {
+		return []string{}
+	}
+	args := []string{}
+	if sessionContext != "" {
+		args = append(args, "--context="+sessionContext)
+	}
+	if sessionNamespace != "" {
+		args = append(args, "--namespace="+sessionNamespace)
+	}
+
+	return args
+}
@@ -0,0 +1,160 @@
+package kubectl
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brettcodling/Kubessh/pkg/database"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var (
+	// kubeconfigPaths are the kubeconfig files registered in Settings. When
+	// empty kubectl's own lookup (KUBECONFIG or ~/.kube/config) is used.
+	kubeconfigPaths []string
+	// activeKubeconfig is the registered file holding the selected context.
+	activeKubeconfig string
+)
+
+func init() {
+	setKubeconfigPaths(splitKubeconfigPaths(database.Get("KUBECONFIGS")))
+}
+
+func splitKubeconfigPaths(value string) []string {
+	paths := []string{}
+	for _, path := range strings.Split(value, "\n") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+func setKubeconfigPaths(paths []string) {
+	kubeconfigPaths = paths
+	activeKubeconfig = database.Get("ACTIVE_KUBECONFIG")
+	for _, path := range kubeconfigPaths {
+		if path == activeKubeconfig {
+			return
+		}
+	}
+	activeKubeconfig = ""
+	if len(kubeconfigPaths) > 0 {
+		activeKubeconfig = kubeconfigPaths[0]
+	}
+}
+
+func setActiveKubeconfig(path string) {
+	activeKubeconfig = path
+	database.Set("ACTIVE_KUBECONFIG", path)
+}
+
+func loadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if activeKubeconfig != "" {
+		rules.ExplicitPath = activeKubeconfig
+	}
+
+	return rules
+}
+
+// loadKubeconfig reads the kubeconfig holding the selected context. Without
+// registered files it merges the files kubectl would read.
+func loadKubeconfig() (*clientcmdapi.Config, error) {
+	return loadingRules().Load()
+}
+
+// modifyKubeconfig applies change to the kubeconfig holding the selected
+// context and writes it back the way kubectl config does, so that switching
+// doesn't need kubectl.
+func modifyKubeconfig(change func(config *clientcmdapi.Config) error) error {
+	rules := loadingRules()
+	config, err := rules.GetStartingConfig()
+	if err != nil {
+		return err
+	}
+	if err := change(config); err != nil {
+		return err
+	}
+
+	return clientcmd.ModifyConfig(rules, *config, true)
+}
+
+// loadContexts lists the contexts of every registered kubeconfig file, each
+// file read on its own so that they never need to be merged.
+func loadContexts() ([]*Context, error) {
+	if len(kubeconfigPaths) == 0 {
+		config, err := loadKubeconfig()
+		if err != nil {
+			return nil, err
+		}
+
+		return contextsFromKubeconfig(config, ""), nil
+	}
+
+	contexts := []*Context{}
+	for _, path := range kubeconfigPaths {
+		config, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, context := range contextsFromKubeconfig(config, path) {
+			context.InUse = context.InUse && path == activeKubeconfig
+			contexts = append(contexts, context)
+		}
+	}
+
+	return contexts, nil
+}
+
+func contextsFromKubeconfig(config *clientcmdapi.Config, file string) []*Context {
+	contexts := []*Context{}
+	for name, c := range config.Contexts {
+		context := &Context{
+			Name:      name,
+			Cluster:   c.Cluster,
+			User:      c.AuthInfo,
+			Namespace: c.Namespace,
+			File:      c.LocationOfOrigin,
+			InUse:     name == config.CurrentContext,
+		}
+		if file != "" {
+			context.File = file
+		}
+		contexts = append(contexts, context)
+	}
+	sort.Slice(contexts, func(i, j int) bool {
+		return contexts[i].Name < contexts[j].Name
+	})
+
+	return contexts
+}
+
+// groupContextsByFile returns the files contexts were read from in the order
+// they were first seen.
+func groupContextsByFile(contexts []*Context) ([]string, map[string][]*Context) {
+	files := []string{}
+	groups := make(map[string][]*Context)
+	for _, context := range contexts {
+		if _, ok := groups[context.File]; !ok {
+			files = append(files, context.File)
+		}
+		groups[context.File] = append(groups[context.File], context)
+	}
+
+	return files, groups
+}
+
+func kubeconfigTitle(file string) string {
+	if file == "" {
+		return "kubeconfig"
+	}
+
+	return filepath.Base(file)
+}
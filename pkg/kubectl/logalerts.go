@@ -0,0 +1,344 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/style"
+	"github.com/brettcodling/Kubessh/pkg/database"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+	"github.com/brettcodling/systray"
+)
+
+const defaultLogAlertInterval = 60
+
+// LogAlert raises a notification when a line matching Pattern appears in the
+// logs of the pods selected by Selector or Workload. Alerts follow the
+// current context and namespace.
+type LogAlert struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Selector string `json:"selector,omitempty"`
+	Workload string `json:"workload,omitempty"`
+	// Interval is the least number of seconds between two notifications.
+	// Matches in between are counted and reported with the next one.
+	Interval int  `json:"interval"`
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+func (alert LogAlert) target() TailTarget {
+	return TailTarget{Selector: alert.Selector, Workload: alert.Workload}
+}
+
+var (
+	logAlertsMu       sync.Mutex
+	logAlertCancel    = make(map[string]context.CancelFunc)
+	logAlertItems     = make(map[string]*systray.MenuItem)
+	logAlertsMenuItem *systray.MenuItem
+	logAlertsWindow   nucular.MasterWindow
+
+	// logAlerts is the alerts as last loaded by SetLogAlerts, so that the
+	// window, which is redrawn every frame, doesn't read the database.
+	logAlerts []LogAlert
+
+	alertName, alertTarget, alertPattern, alertInterval nucular.TextEditor
+	alertTargetKind                                     int
+)
+
+func init() {
+	for _, editor := range []*nucular.TextEditor{&alertName, &alertTarget, &alertPattern, &alertInterval} {
+		editor.Flags = nucular.EditField
+		editor.SingleLine = true
+	}
+}
+
+func validateLogAlert(alert LogAlert) error {
+	if strings.TrimSpace(alert.Name) == "" {
+		return errors.New("alert name is empty")
+	}
+	if strings.ContainsFunc(alert.Name, unicode.IsControl) {
+		return fmt.Errorf("invalid alert name %q: contains control characters", alert.Name)
+	}
+	if alert.Pattern == "" {
+		return errors.New("alert pattern is empty")
+	}
+	if _, err := regexp.Compile(alert.Pattern); err != nil {
+		return fmt.Errorf("invalid alert pattern %q: %w", alert.Pattern, err)
+	}
+	if alert.Interval < 1 {
+		return fmt.Errorf("invalid alert interval %d: must be at least one second", alert.Interval)
+	}
+	_, err := validateTailTarget(alert.target())
+
+	return err
+}
+
+func getLogAlerts() []LogAlert {
+	alerts := []LogAlert{}
+	for _, value := range database.List("LOG-ALERT-") {
+		var alert LogAlert
+		if err := json.Unmarshal([]byte(value), &alert); err != nil {
+			log.Println(err)
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Name < alerts[j].Name
+	})
+
+	return alerts
+}
+
+func saveLogAlert(alert LogAlert) error {
+	if err := validateLogAlert(alert); err != nil {
+		return err
+	}
+	value, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	return database.Set("LOG-ALERT-"+alert.Name, string(value))
+}
+
+// addLogAlert saves a new alert, refusing to replace one with the same name.
+func addLogAlert(alert LogAlert) error {
+	if database.Get("LOG-ALERT-"+alert.Name) != "" {
+		return fmt.Errorf("an alert named %q already exists", alert.Name)
+	}
+
+	return saveLogAlert(alert)
+}
+
+func listLogAlerts() []LogAlert {
+	logAlertsMu.Lock()
+	defer logAlertsMu.Unlock()
+
+	return append([]LogAlert{}, logAlerts...)
+}
+
+// AddLogAlerts adds the alerts submenu and starts every enabled alert. They
+// restart whenever the context or namespace changes.
+func AddLogAlerts() {
+	logAlertsMenuItem = systray.AddMenuItem("Log Alerts", "")
+	manage := logAlertsMenuItem.AddSubMenuItem("Manage...", "")
+	go func() {
+		for range manage.ClickedCh {
+			OpenLogAlerts()
+		}
+	}()
+	SetLogAlerts()
+
+	events, _ := Subscribe()
+	go func() {
+		for event := range events {
+			if event.Type == EventContext {
+				restartLogAlerts()
+			}
+		}
+	}()
+}
+
+// SetLogAlerts rebuilds the alert menu items from the database and starts
+// or stops alerts to match.
+func SetLogAlerts() {
+	logAlertsMu.Lock()
+	defer logAlertsMu.Unlock()
+	for name, item := range logAlertItems {
+		item.Remove()
+		delete(logAlertItems, name)
+	}
+	for name, cancel := range logAlertCancel {
+		cancel()
+		delete(logAlertCancel, name)
+	}
+	logAlerts = getLogAlerts()
+	for _, alert := range logAlerts {
+		item := logAlertsMenuItem.AddSubMenuItemCheckbox(alert.Name, alert.target().String()+": "+alert.Pattern, !alert.Disabled)
+		logAlertItems[alert.Name] = item
+		go func(alert LogAlert) {
+			for range item.ClickedCh {
+				alert.Disabled = !alert.Disabled
+				if err := saveLogAlert(alert); err != nil {
+					log.Println(err)
+					notify.Warning("ERROR!", err.Error())
+					continue
+				}
+				go SetLogAlerts()
+				return
+			}
+		}(alert)
+		if !alert.Disabled {
+			startLogAlert(alert)
+		}
+	}
+}
+
+func restartLogAlerts() {
+	logAlertsMu.Lock()
+	defer logAlertsMu.Unlock()
+	if len(logAlertCancel) == 0 {
+		return
+	}
+	for name, cancel := range logAlertCancel {
+		cancel()
+		delete(logAlertCancel, name)
+	}
+	for _, alert := range logAlerts {
+		if !alert.Disabled {
+			startLogAlert(alert)
+		}
+	}
+}
+
+// startLogAlert must be called with logAlertsMu held.
+func startLogAlert(alert LogAlert) {
+	pattern, err := regexp.Compile(alert.Pattern)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	logAlertCancel[alert.Name] = cancel
+	go runLogAlert(ctx, alert, pattern)
+}
+
+func runLogAlert(ctx context.Context, alert LogAlert, pattern *regexp.Regexp) {
+	interval := time.Duration(alert.Interval) * time.Second
+	var (
+		mu         sync.Mutex
+		lastNotify time.Time
+		suppressed int
+	)
+	// Matches held back by the rate limit are reported once it allows.
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			mu.Lock()
+			if suppressed > 0 && time.Since(lastNotify) >= interval {
+				notify.Warning("Log alert: "+alert.Name, strconv.Itoa(suppressed)+" more matching lines")
+				suppressed = 0
+				lastNotify = time.Now()
+			}
+			mu.Unlock()
+		}
+	}()
+
+	// Only lines logged from now on are read, including those of containers
+	// that start later.
+	err := TailSince(ctx, alert.target(), time.Now(), func(line TailLine) {
+		if !pattern.MatchString(line.Text) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(lastNotify) < interval {
+			suppressed++
+			return
+		}
+		text := line.Text
+		if len(text) > 200 {
+			text = text[:200] + "..."
+		}
+		if suppressed > 0 {
+			text += " (and " + strconv.Itoa(suppressed) + " more)"
+		}
+		notify.Warning("Log alert: "+alert.Name, line.Prefix()+" "+text)
+		suppressed = 0
+		lastNotify = time.Now()
+	})
+	if err != nil {
+		log.Println(err)
+		notify.Warning("ERROR!", "Log alert "+alert.Name+": "+err.Error())
+	}
+}
+
+func OpenLogAlerts() {
+	if logAlertsWindow != nil {
+		logAlertsWindow.Close()
+	}
+	for _, editor := range []*nucular.TextEditor{&alertName, &alertTarget, &alertPattern} {
+		editor.SelectAll()
+		editor.Text([]rune{})
+	}
+	alertInterval.SelectAll()
+	alertInterval.Text([]rune(strconv.Itoa(defaultLogAlertInterval)))
+	logAlertsWindow = nucular.NewMasterWindow(0, "Log Alerts", updateLogAlerts)
+	logAlertsWindow.SetStyle(style.FromTheme(style.DarkTheme, 2.0))
+	logAlertsWindow.Main()
+}
+
+func updateLogAlerts(w *nucular.Window) {
+	for _, alert := range listLogAlerts() {
+		w.Row(30).Ratio(0.25, 0.3, 0.3, 0.15)
+		w.Label(alert.Name, "LC")
+		w.Label(alert.target().String(), "LC")
+		w.Label(alert.Pattern, "LC")
+		if w.ButtonText("Delete") {
+			if err := database.Delete("LOG-ALERT-" + alert.Name); err != nil {
+				log.Println(err)
+				notify.Warning("ERROR!", err.Error())
+			}
+			go SetLogAlerts()
+		}
+	}
+	w.Row(40).Dynamic(1)
+	w.Label("New alert:", "LC")
+	w.Row(30).Dynamic(2)
+	w.Label("Name:", "LC")
+	alertName.Edit(w)
+	w.Row(30).Dynamic(2)
+	alertTargetKind = w.ComboSimple([]string{"Label selector", "Workload (kind/name)"}, alertTargetKind, 20)
+	alertTarget.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Pattern (regular expression):", "LC")
+	alertPattern.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Seconds between notifications:", "LC")
+	alertInterval.Edit(w)
+	w.Row(30).Dynamic(1)
+	if w.ButtonText("Add") {
+		interval, err := strconv.Atoi(string(alertInterval.Buffer))
+		if err != nil {
+			interval = 0
+		}
+		alert := LogAlert{
+			Name:     strings.TrimSpace(string(alertName.Buffer)),
+			Pattern:  string(alertPattern.Buffer),
+			Interval: interval,
+		}
+		if alertTargetKind == 0 {
+			alert.Selector = strings.TrimSpace(string(alertTarget.Buffer))
+		} else {
+			alert.Workload = strings.TrimSpace(string(alertTarget.Buffer))
+		}
+		if err := addLogAlert(alert); err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", err.Error())
+			return
+		}
+		for _, editor := range []*nucular.TextEditor{&alertName, &alertTarget, &alertPattern} {
+			editor.SelectAll()
+			editor.Text([]rune{})
+		}
+		go SetLogAlerts()
+	}
+}
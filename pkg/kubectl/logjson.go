@@ -0,0 +1,174 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Common spellings of the fields most JSON loggers write. A column or filter
+// naming one of these keys also matches the others.
+var logFieldAliases = map[string][]string{
+	"level": {"level", "lvl", "severity", "log.level"},
+	"msg":   {"msg", "message", "log"},
+	"time":  {"time", "ts", "timestamp", "@timestamp"},
+}
+
+// LogLevels are the levels the log viewer can filter on. Lines without a
+// recognised level count as "other".
+var LogLevels = []string{"debug", "info", "warn", "error", "other"}
+
+const defaultLogColumns = "time, level, msg"
+
+// parseJSONLog returns the fields of a JSON object line, or nil when the
+// line is not one.
+func parseJSONLog(text string) map[string]interface{} {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "{") || !strings.HasSuffix(text, "}") {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return nil
+	}
+
+	return fields
+}
+
+// logField looks name up in fields, following dots into nested objects and
+// trying the aliases of well-known names. It returns the key that matched.
+func logField(fields map[string]interface{}, name string) (string, interface{}, bool) {
+	names := logFieldAliases[name]
+	if names == nil {
+		names = []string{name}
+	}
+	for _, key := range names {
+		if value, ok := fields[key]; ok {
+			return key, value, true
+		}
+		var value interface{} = fields
+		found := true
+		for _, part := range strings.Split(key, ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if value, ok = object[part]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			return key, value, true
+		}
+	}
+
+	return "", nil, false
+}
+
+func formatLogValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case nil:
+		return "null"
+	case map[string]interface{}, []interface{}:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// logLevel normalises the line's level to one of LogLevels.
+func logLevel(fields map[string]interface{}) string {
+	_, value, ok := logField(fields, "level")
+	if !ok {
+		return "other"
+	}
+	switch level := strings.ToLower(formatLogValue(value)); level {
+	case "trace", "debug":
+		return "debug"
+	case "info", "information", "notice":
+		return "info"
+	case "warn", "warning":
+		return "warn"
+	case "error", "err", "fatal", "panic", "critical", "crit", "alert", "emerg", "emergency":
+		return "error"
+	default:
+		return "other"
+	}
+}
+
+func splitLogColumns(columns string) []string {
+	names := []string{}
+	for _, name := range strings.Split(columns, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// logFieldFilter is a key=value condition on a JSON line's fields. With
+// NotEqual set it is key!=value instead.
+type logFieldFilter struct {
+	Key      string
+	Value    string
+	NotEqual bool
+}
+
+func parseLogFieldFilters(filters string) ([]logFieldFilter, error) {
+	parsed := []logFieldFilter{}
+	for _, filter := range strings.Fields(filters) {
+		key, value, ok := strings.Cut(filter, "!=")
+		notEqual := ok
+		if !ok {
+			key, value, ok = strings.Cut(filter, "=")
+		}
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid field filter %q: must be key=value or key!=value", filter)
+		}
+		parsed = append(parsed, logFieldFilter{Key: key, Value: value, NotEqual: notEqual})
+	}
+
+	return parsed, nil
+}
+
+func (filter logFieldFilter) matches(fields map[string]interface{}) bool {
+	_, value, ok := logField(fields, filter.Key)
+	equal := ok && formatLogValue(value) == filter.Value
+
+	return equal != filter.NotEqual
+}
+
+// logColumns returns the values of columns in fields, and the remaining
+// fields formatted as "key: value" in key order for the detail view.
+func logColumns(fields map[string]interface{}, columns []string) ([]string, []string) {
+	values := make([]string, len(columns))
+	used := make(map[string]bool)
+	for i, column := range columns {
+		if key, value, ok := logField(fields, column); ok {
+			values[i] = formatLogValue(value)
+			if !strings.Contains(key, ".") {
+				used[key] = true
+			}
+		}
+	}
+	keys := []string{}
+	for key := range fields {
+		if !used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	details := []string{}
+	for _, key := range keys {
+		details = append(details, key+": "+formatLogValue(fields[key]))
+	}
+
+	return values, details
+}
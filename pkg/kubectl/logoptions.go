@@ -0,0 +1,122 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/aarzilli/nucular"
+	"github.com/brettcodling/Kubessh/pkg/database"
+)
+
+// LogPreference is how logs are fetched for a container. It is saved per
+// workload and container so it survives pod replacement.
+type LogPreference struct {
+	Previous      bool   `json:"previous,omitempty"`
+	Since         string `json:"since,omitempty"`
+	SinceTime     string `json:"sinceTime,omitempty"`
+	AllContainers bool   `json:"allContainers,omitempty"`
+}
+
+var (
+	logOptionsOpen         bool
+	logOptionsKey          string
+	logPreference          LogPreference
+	logSince, logSinceTime nucular.TextEditor
+)
+
+func init() {
+	for _, editor := range []*nucular.TextEditor{&logSince, &logSinceTime} {
+		editor.Flags = nucular.EditField
+		editor.SingleLine = true
+	}
+}
+
+func logPreferenceKey(pod Pod, container string) string {
+	return "LOG-OPTIONS-" + pod.Workload + "/" + container
+}
+
+func loadLogPreference(key string) LogPreference {
+	var preference LogPreference
+	if value := database.Get(key); value != "" {
+		if err := json.Unmarshal([]byte(value), &preference); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return preference
+}
+
+func saveLogPreference(key string, preference LogPreference) error {
+	if preference == (LogPreference{}) {
+		return database.Delete(key)
+	}
+	value, err := json.Marshal(preference)
+	if err != nil {
+		return err
+	}
+
+	return database.Set(key, string(value))
+}
+
+// logOptions are the options for following a container's logs with its
+// saved preference applied.
+func (pod Pod) logOptions(container string) (LogOptions, error) {
+	preference := loadLogPreference(logPreferenceKey(pod, container))
+	opts := LogOptions{
+		Pod:           pod.Name,
+		Container:     container,
+		Follow:        true,
+		Tail:          tailString,
+		Timestamps:    true,
+		Previous:      preference.Previous,
+		Since:         preference.Since,
+		SinceTime:     preference.SinceTime,
+		AllContainers: preference.AllContainers,
+	}
+	if err := validateLogOptions(opts); err != nil {
+		return LogOptions{}, err
+	}
+
+	return opts, nil
+}
+
+// updateLogOptions edits the log preference of the selected container in
+// the pod window. Valid changes are saved as they are made, like the port
+// fields.
+func updateLogOptions(w *nucular.Window, pod Pod, container string) {
+	if key := logPreferenceKey(pod, container); key != logOptionsKey {
+		logOptionsKey = key
+		logPreference = loadLogPreference(key)
+		logSince.SelectAll()
+		logSince.Text([]rune(logPreference.Since))
+		logSinceTime.SelectAll()
+		logSinceTime.Text([]rune(logPreference.SinceTime))
+	}
+	changed := logPreference
+	w.Row(30).Dynamic(2)
+	w.CheckboxText("Previous container", &changed.Previous)
+	w.CheckboxText("All containers", &changed.AllContainers)
+	w.Row(30).Dynamic(2)
+	w.Label("Since (e.g. 1h):", "LC")
+	logSince.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Since time (RFC3339):", "LC")
+	logSinceTime.Edit(w)
+	changed.Since = strings.TrimSpace(string(logSince.Buffer))
+	changed.SinceTime = strings.TrimSpace(string(logSinceTime.Buffer))
+	err := validateLogOptions(LogOptions{Since: changed.Since, SinceTime: changed.SinceTime})
+	if changed != logPreference {
+		logPreference = changed
+		// A value being typed is only saved once it is valid.
+		if err == nil {
+			if err := saveLogPreference(logOptionsKey, logPreference); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	if err != nil {
+		w.Row(30).Dynamic(1)
+		w.Label(err.Error(), "LC")
+	}
+}
@@ -0,0 +1,421 @@
+package kubectl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+	"github.com/brettcodling/systray"
+)
+
+const defaultRecordMaxSize = "10"
+
+// recordFileTime names each file after the time it was opened. It sorts in
+// order and avoids characters some filesystems reject.
+const recordFileTime = "20060102T150405"
+
+// LogRecording describes a container whose logs are being written to disk.
+type LogRecording struct {
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Path      string    `json:"path"`
+	Until     time.Time `json:"until,omitempty"`
+}
+
+type logRecorder struct {
+	recording LogRecording
+	target    ClusterTarget
+	cancel    context.CancelFunc
+	item      *systray.MenuItem
+}
+
+var (
+	recordMu       sync.Mutex
+	recorders      = make(map[recordKey]*logRecorder)
+	recordMenuItem *systray.MenuItem
+	recordOpen     bool
+
+	recordDir, recordMaxSize, recordFor  nucular.TextEditor
+	recordDirString, recordMaxSizeString string
+	recordGzip, recordGzipSetting        bool
+)
+
+func init() {
+	for _, editor := range []*nucular.TextEditor{&recordDir, &recordMaxSize, &recordFor} {
+		editor.Flags = nucular.EditField
+		editor.SingleLine = true
+	}
+}
+
+// recordDirectory is where recordings are written, ~/kubessh-logs unless
+// RECORD_DIR is set.
+func recordDirectory() string {
+	if recordDirString != "" {
+		return recordDirString
+	}
+	home, _ := os.UserHomeDir()
+
+	return filepath.Join(home, "kubessh-logs")
+}
+
+func recordMaxBytes() int64 {
+	size, err := strconv.Atoi(recordMaxSizeString)
+	if err != nil || size < 1 {
+		size, _ = strconv.Atoi(defaultRecordMaxSize)
+	}
+
+	return int64(size) * 1024 * 1024
+}
+
+func validateRecordSettings(dir, maxSize string) error {
+	if dir != "" && !filepath.IsAbs(dir) {
+		return fmt.Errorf("invalid record directory %q: must be an absolute path", dir)
+	}
+	if size, err := strconv.Atoi(maxSize); err != nil || size < 1 {
+		return fmt.Errorf("invalid record file size %q: must be a positive number of megabytes", maxSize)
+	}
+
+	return nil
+}
+
+func AddLogRecording() {
+	recordMenuItem = systray.AddMenuItem("Recording Logs:", "")
+	recordMenuItem.Hide()
+}
+
+// recordKey tells recordings apart by cluster as well as by pod and
+// container, since the same names can exist in other contexts and
+// namespaces.
+type recordKey struct {
+	ClusterTarget
+	pod, container string
+}
+
+func (key recordKey) String() string {
+	title := key.Namespace + "/" + key.pod + "/" + key.container
+	if key.Context != getCurrentContext().Name {
+		title = key.Context + "/" + title
+	}
+
+	return title
+}
+
+func isRecording(pod, container string) bool {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	_, ok := recorders[recordKey{currentClusterTarget(), pod, container}]
+
+	return ok
+}
+
+// startRecording writes the logs of container to files under the record
+// directory until stopped, or until duration has passed when it is set.
+func (pod Pod) startRecording(container string, duration time.Duration) error {
+	if err := validatePodTarget(pod.Name, container); err != nil {
+		return err
+	}
+	key := recordKey{currentClusterTarget(), pod.Name, container}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if _, ok := recorders[key]; ok {
+		return fmt.Errorf("%s is already being recorded", key)
+	}
+
+	// Files are grouped by workload so that recordings of a pod's
+	// replacements end up next to each other.
+	workload := pod.Workload
+	if workload == "" {
+		workload = "pod/" + pod.Name
+	}
+	dir := filepath.Join(recordDirectory(), strings.ReplaceAll(workload, "/", "-"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	recording := LogRecording{
+		Pod:       pod.Name,
+		Container: container,
+		Path:      dir,
+	}
+	title := key.String()
+	ctx, cancel := context.WithCancel(context.Background())
+	if duration > 0 {
+		recording.Until = time.Now().Add(duration)
+		title += " (until " + recording.Until.Format("15:04") + ")"
+		ctx, cancel = context.WithDeadline(ctx, recording.Until)
+	}
+	recordMenuItem.Show()
+	recorder := &logRecorder{
+		recording: recording,
+		target:    key.ClusterTarget,
+		cancel:    cancel,
+		item:      recordMenuItem.AddSubMenuItem(title, "Stop recording to "+dir),
+	}
+	recorders[key] = recorder
+	go func() {
+		for {
+			select {
+			case <-recorder.item.ClickedCh:
+				recorder.stop()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		recorder.record(ctx)
+		if ctx.Err() == context.DeadlineExceeded {
+			notify.Info("Recording finished", key.String()+" was recorded to "+dir)
+		}
+		recorder.stop()
+	}()
+
+	return nil
+}
+
+func stopRecording(pod, container string) {
+	recordMu.Lock()
+	recorder, ok := recorders[recordKey{currentClusterTarget(), pod, container}]
+	recordMu.Unlock()
+	if ok {
+		recorder.stop()
+	}
+}
+
+func (recorder *logRecorder) key() recordKey {
+	return recordKey{recorder.target, recorder.recording.Pod, recorder.recording.Container}
+}
+
+// stop ends the recording. Its entry is only removed while it is still the
+// one recorded under its key, since a new recording of the same container
+// may have been started since it was stopped from the menu.
+func (recorder *logRecorder) stop() {
+	key := recorder.key()
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	recorder.cancel()
+	if recorders[key] != recorder {
+		return
+	}
+	recorder.item.Remove()
+	delete(recorders, key)
+	if len(recorders) < 1 {
+		recordMenuItem.Hide()
+	}
+}
+
+// record streams the container's logs into rotating files until ctx is
+// done. A stream that ends, such as when the container restarts, is resumed
+// from the last line written.
+func (recorder *logRecorder) record(ctx context.Context) {
+	writer := &rotatingWriter{
+		dir:     recorder.recording.Path,
+		prefix:  recorder.recording.Pod + "_" + recorder.recording.Container,
+		maxSize: recordMaxBytes(),
+		gzip:    recordGzip,
+	}
+	defer writer.Close()
+	// Keep recording from the cluster it was started on when the tray
+	// switches to another context or namespace.
+	backend := backendFor(recorder.target)
+	var last time.Time
+	for {
+		opts := LogOptions{
+			Pod:        recorder.recording.Pod,
+			Container:  recorder.recording.Container,
+			Follow:     true,
+			Tail:       "0",
+			Timestamps: true,
+		}
+		if !last.IsZero() {
+			opts.Tail = ""
+			opts.SinceTime = last.Format(time.RFC3339)
+		}
+		err := recordStream(ctx, backend, opts, writer, &last)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", "Recording "+recorder.key().String()+": "+err.Error())
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func recordStream(ctx context.Context, backend Backend, opts LogOptions, writer *rotatingWriter, last *time.Time) error {
+	reader, pipe := io.Pipe()
+	go func() {
+		pipe.CloseWithError(backend.Logs(ctx, opts, pipe))
+	}()
+	resume := *last
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		stamp, _, skip := parseTimestampedLine(line, resume)
+		if skip {
+			continue
+		}
+		if !stamp.IsZero() {
+			*last = stamp
+		}
+		if _, err := writer.Write([]byte(line + "\n")); err != nil {
+			reader.CloseWithError(err)
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return scanner.Err()
+}
+
+// rotatingWriter writes to <prefix>_<time>.log files in dir, starting a new
+// file once the current one reaches maxSize. With gzip set, files are
+// compressed once they are full.
+type rotatingWriter struct {
+	dir     string
+	prefix  string
+	maxSize int64
+	gzip    bool
+
+	file *os.File
+	size int64
+}
+
+func (writer *rotatingWriter) Write(data []byte) (int, error) {
+	if writer.file != nil && writer.size+int64(len(data)) > writer.maxSize {
+		if err := writer.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if writer.file == nil {
+		file, err := writer.create()
+		if err != nil {
+			return 0, err
+		}
+		writer.file = file
+	}
+	written, err := writer.file.Write(data)
+	writer.size += int64(written)
+
+	return written, err
+}
+
+// create opens a new file named after the current time, numbering it when
+// a file for this second already exists.
+func (writer *rotatingWriter) create() (*os.File, error) {
+	base := filepath.Join(writer.dir, writer.prefix+"_"+time.Now().Format(recordFileTime))
+	for i := 0; ; i++ {
+		path := base + ".log"
+		if i > 0 {
+			path = base + "-" + strconv.Itoa(i) + ".log"
+		}
+		if _, err := os.Stat(path + ".gz"); err == nil {
+			continue
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return file, err
+	}
+}
+
+func (writer *rotatingWriter) Close() error {
+	if writer.file == nil {
+		return nil
+	}
+	path := writer.file.Name()
+	err := writer.file.Close()
+	writer.file, writer.size = nil, 0
+	if err == nil && writer.gzip {
+		go func() {
+			if err := gzipFile(path); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	return err
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	compressor := gzip.NewWriter(target)
+	if _, err := io.Copy(compressor, source); err != nil {
+		target.Close()
+		os.Remove(target.Name())
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		target.Close()
+		os.Remove(target.Name())
+		return err
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(target.Name())
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func updateRecording(w *nucular.Window, pod Pod, container string) {
+	w.Row(30).Dynamic(2)
+	if isRecording(pod.Name, container) {
+		w.Spacing(1)
+		if w.ButtonText("Stop Recording") {
+			stopRecording(pod.Name, container)
+		}
+		return
+	}
+	w.Label("Record for (empty until stopped):", "LC")
+	recordFor.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("To "+recordDirectory(), "LC")
+	if w.ButtonText("Record") {
+		var duration time.Duration
+		if value := strings.TrimSpace(string(recordFor.Buffer)); value != "" {
+			var err error
+			duration, err = time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				err = fmt.Errorf("invalid duration %q: must be a positive duration such as 30m or 1h", value)
+				log.Println(err)
+				notify.Warning("ERROR!", err.Error())
+				return
+			}
+		}
+		if err := pod.startRecording(container, duration); err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", err.Error())
+		}
+	}
+}
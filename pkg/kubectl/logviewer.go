@@ -0,0 +1,509 @@
+package kubectl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+	"github.com/aarzilli/nucular/style"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+)
+
+// logViewerLines bounds how many lines a log viewer keeps. Older lines are
+// dropped as new ones arrive.
+const logViewerLines = 10000
+
+const logViewerRowHeight = 20
+
+var (
+	logHighlight    = color.RGBA{0x80, 0x60, 0x00, 0xff}
+	logPrefixColors = []color.RGBA{
+		{0x1f, 0x5f, 0x9f, 0xff},
+		{0x2f, 0x7f, 0x3f, 0xff},
+		{0x8f, 0x3f, 0x8f, 0xff},
+		{0x9f, 0x4f, 0x1f, 0xff},
+		{0x1f, 0x7f, 0x7f, 0xff},
+		{0x7f, 0x2f, 0x3f, 0xff},
+	}
+)
+
+// viewLine is a logLine as shown, after the timestamp toggle is applied.
+type viewLine struct {
+	Prefix string
+	Color  int
+	Text   string
+	ID     int
+	Fields map[string]interface{}
+}
+
+func (line viewLine) String() string {
+	if line.Prefix == "" {
+		return line.Text
+	}
+
+	return line.Prefix + " " + line.Text
+}
+
+type logLine struct {
+	Prefix string
+	// Color numbers the prefix's highlight color, or 0 for none.
+	Color int
+	Time  string
+	Text  string
+
+	// ID numbers lines in arrival order, and Fields holds the line parsed as
+	// JSON, or nil.
+	ID     int
+	Fields map[string]interface{}
+}
+
+// parseLogLine splits a line streamed with timestamps, and with a container
+// prefix when all containers are streamed.
+func parseLogLine(raw string, prefixed bool) logLine {
+	var line logLine
+	if prefixed && strings.HasPrefix(raw, "[") {
+		if end := strings.Index(raw, "] "); end >= 0 {
+			line.Prefix, raw = raw[:end+1], raw[end+2:]
+		}
+	}
+	timestamp, text, ok := strings.Cut(raw, " ")
+	if !ok {
+		timestamp, text = "", timestamp
+	}
+	line.Time, line.Text = timestamp, text
+
+	return line
+}
+
+// logViewer streams the logs of one container into a window.
+type logViewer struct {
+	mu      sync.Mutex
+	lines   []logLine
+	start   int
+	dropped int
+	status  string
+
+	nextID int
+
+	window     nucular.MasterWindow
+	follow     bool
+	timestamps bool
+	scrolled   int
+
+	filter, search, savePath nucular.TextEditor
+	searchString             string
+	searchRegexp             *regexp.Regexp
+	searchErr                error
+
+	// Structured mode shows JSON lines as columns with their other fields in
+	// an expandable detail view.
+	structured           bool
+	columns, fieldFilter nucular.TextEditor
+	levels               map[string]bool
+	fieldFilterString    string
+	fieldFilters         []logFieldFilter
+	fieldFilterErr       error
+	expanded             map[int]bool
+}
+
+func (pod Pod) viewLogs(container string) error {
+	if err := validatePodTarget(pod.Name, container); err != nil {
+		return err
+	}
+	if err := validateTail(tailString); err != nil {
+		return err
+	}
+	opts, err := pod.logOptions(container)
+	if err != nil {
+		return err
+	}
+	openLogViewer(pod.Name+" "+container, pod.Name+"-"+container, func(ctx context.Context, add func(logLine)) error {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(getBackend().Logs(ctx, opts, writer))
+		}()
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			add(parseLogLine(scanner.Text(), opts.AllContainers))
+		}
+
+		return scanner.Err()
+	})
+
+	return nil
+}
+
+// viewTail opens a log viewer merging the logs of every pod matching target.
+func viewTail(target TailTarget) error {
+	if _, err := validateTailTarget(target); err != nil {
+		return err
+	}
+	if err := validateTail(tailString); err != nil {
+		return err
+	}
+	name := strings.NewReplacer("/", "-", " ", "", "=", "-", ",", "-").Replace(target.String())
+	openLogViewer(target.String(), name, func(ctx context.Context, add func(logLine)) error {
+		return Tail(ctx, target, tailString, func(line TailLine) {
+			add(logLine{
+				Prefix: line.Prefix(),
+				Color:  line.Color + 1,
+				Time:   line.Time.Format(time.RFC3339Nano),
+				Text:   line.Text,
+			})
+		})
+	})
+
+	return nil
+}
+
+// openLogViewer shows the lines from source in a new window until it is
+// closed. fileName names the default file to save to.
+func openLogViewer(title, fileName string, source func(ctx context.Context, add func(logLine)) error) {
+	viewer := &logViewer{
+		follow:   true,
+		status:   "Streaming",
+		levels:   make(map[string]bool),
+		expanded: make(map[int]bool),
+	}
+	for _, level := range LogLevels {
+		viewer.levels[level] = true
+	}
+	viewer.columns.Text([]rune(defaultLogColumns))
+	for _, editor := range []*nucular.TextEditor{&viewer.filter, &viewer.search, &viewer.savePath, &viewer.columns, &viewer.fieldFilter} {
+		editor.Flags = nucular.EditField
+		editor.SingleLine = true
+	}
+	home, _ := os.UserHomeDir()
+	viewer.savePath.Text([]rune(filepath.Join(home, fileName+".log")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	viewer.window = nucular.NewMasterWindow(0, "Logs: "+title, viewer.update)
+	viewer.window.SetStyle(style.FromTheme(style.DarkTheme, 2.0))
+	go func() {
+		err := source(ctx, viewer.add)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil:
+			log.Println(err)
+			viewer.setStatus("Stopped: " + err.Error())
+		default:
+			viewer.setStatus("Stream ended")
+		}
+	}()
+	viewer.window.Main()
+	cancel()
+}
+
+func (viewer *logViewer) setStatus(status string) {
+	viewer.mu.Lock()
+	viewer.status = status
+	viewer.mu.Unlock()
+	viewer.window.Changed()
+}
+
+func (viewer *logViewer) add(line logLine) {
+	line.Fields = parseJSONLog(line.Text)
+	viewer.mu.Lock()
+	line.ID = viewer.nextID
+	viewer.nextID++
+	if len(viewer.lines) < logViewerLines {
+		viewer.lines = append(viewer.lines, line)
+	} else {
+		viewer.lines[viewer.start] = line
+		viewer.start = (viewer.start + 1) % logViewerLines
+		viewer.dropped++
+	}
+	viewer.mu.Unlock()
+	viewer.window.Changed()
+}
+
+// matchesStructured applies the level and field filters. Lines that aren't
+// JSON have no level and never match a field filter.
+func (viewer *logViewer) matchesStructured(fields map[string]interface{}) bool {
+	if !viewer.levels[logLevel(fields)] {
+		return false
+	}
+	for _, filter := range viewer.fieldFilters {
+		if fields == nil || !filter.matches(fields) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// visible returns the buffered lines that pass the filter, oldest first,
+// formatted for display.
+func (viewer *logViewer) visible() []viewLine {
+	viewer.mu.Lock()
+	defer viewer.mu.Unlock()
+
+	filter := string(viewer.filter.Buffer)
+	lines := []viewLine{}
+	for i := range viewer.lines {
+		line := viewer.lines[(viewer.start+i)%len(viewer.lines)]
+		shown := viewLine{Prefix: line.Prefix, Color: line.Color, Text: line.Text, ID: line.ID, Fields: line.Fields}
+		if viewer.timestamps && line.Time != "" {
+			shown.Text = line.Time + " " + shown.Text
+		}
+		if filter != "" && !strings.Contains(shown.String(), filter) {
+			continue
+		}
+		if viewer.structured && !viewer.matchesStructured(line.Fields) {
+			continue
+		}
+		lines = append(lines, shown)
+	}
+
+	return lines
+}
+
+func (viewer *logViewer) update(w *nucular.Window) {
+	if search := string(viewer.search.Buffer); search != viewer.searchString {
+		viewer.searchString = search
+		viewer.searchRegexp, viewer.searchErr = nil, nil
+		if search != "" {
+			viewer.searchRegexp, viewer.searchErr = regexp.Compile(search)
+		}
+	}
+	if fieldFilter := string(viewer.fieldFilter.Buffer); fieldFilter != viewer.fieldFilterString {
+		viewer.fieldFilterString = fieldFilter
+		filters, err := parseLogFieldFilters(fieldFilter)
+		viewer.fieldFilterErr = err
+		if err == nil {
+			viewer.fieldFilters = filters
+		}
+	}
+	lines := viewer.visible()
+	viewer.mu.Lock()
+	status := viewer.status
+	if viewer.dropped > 0 {
+		status += " (" + strconv.Itoa(viewer.dropped) + " older lines dropped)"
+	}
+	viewer.mu.Unlock()
+	if viewer.searchRegexp != nil {
+		matches := 0
+		for _, line := range lines {
+			if viewer.searchRegexp.MatchString(line.String()) {
+				matches++
+			}
+		}
+		status += ", " + strconv.Itoa(matches) + " matching lines"
+	}
+
+	w.Row(30).Dynamic(4)
+	w.CheckboxText("Follow", &viewer.follow)
+	w.CheckboxText("Timestamps", &viewer.timestamps)
+	w.CheckboxText("JSON columns", &viewer.structured)
+	w.Label(status, "LC")
+	if viewer.structured {
+		w.Row(30).Ratio(0.2, 0.8)
+		w.Label("Columns:", "LC")
+		viewer.columns.Edit(w)
+		w.Row(30).Dynamic(len(LogLevels) + 1)
+		w.Label("Levels:", "LC")
+		for _, level := range LogLevels {
+			enabled := viewer.levels[level]
+			if w.CheckboxText(level, &enabled) {
+				viewer.levels[level] = enabled
+			}
+		}
+		w.Row(30).Ratio(0.2, 0.8)
+		w.Label("Fields:", "LC")
+		viewer.fieldFilter.Edit(w)
+		if viewer.fieldFilterErr != nil {
+			w.Row(30).Dynamic(1)
+			w.Label(viewer.fieldFilterErr.Error(), "LC")
+		}
+	}
+	w.Row(30).Ratio(0.2, 0.8)
+	w.Label("Filter:", "LC")
+	viewer.filter.Edit(w)
+	w.Row(30).Ratio(0.2, 0.8)
+	w.Label("Search:", "LC")
+	viewer.search.Edit(w)
+	if viewer.searchErr != nil {
+		w.Row(30).Dynamic(1)
+		w.Label("Invalid regular expression: "+viewer.searchErr.Error(), "LC")
+	}
+	w.Row(30).Ratio(0.2, 0.6, 0.2)
+	w.Label("Save to:", "LC")
+	viewer.savePath.Edit(w)
+	if w.ButtonText("Save") {
+		if err := saveLogLines(string(viewer.savePath.Buffer), lines); err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", err.Error())
+		} else {
+			viewer.setStatus("Saved " + strconv.Itoa(len(lines)) + " lines")
+		}
+	}
+
+	w.RowScaled(w.LayoutAvailableHeight()).Dynamic(1)
+	group := w.GroupBegin("log", 0)
+	if group == nil {
+		return
+	}
+	defer group.GroupEnd()
+	viewer.drawLines(group, lines)
+}
+
+// logRow is one row of the log list: a line, or one field of an expanded
+// JSON line's detail view.
+type logRow struct {
+	line   *viewLine
+	detail string
+}
+
+// drawLines lays out only the rows in view, with spacers standing in for
+// the rest, so that a full buffer stays cheap to redraw.
+func (viewer *logViewer) drawLines(w *nucular.Window, lines []viewLine) {
+	columns := splitLogColumns(string(viewer.columns.Buffer))
+	rows := make([]logRow, 0, len(lines))
+	for i := range lines {
+		rows = append(rows, logRow{line: &lines[i]})
+		if viewer.structured && lines[i].Fields != nil && viewer.expanded[lines[i].ID] {
+			_, details := logColumns(lines[i].Fields, columns)
+			for _, detail := range details {
+				rows = append(rows, logRow{detail: detail})
+			}
+		}
+	}
+
+	masterStyle := w.Master().Style()
+	rowHeight := int(logViewerRowHeight * masterStyle.Scaling)
+	step := rowHeight + w.WindowStyle().Spacing.Y
+	if viewer.follow && len(rows) != viewer.scrolled {
+		w.Scrollbar.Y = len(rows) * step
+		w.Master().Changed()
+	}
+	viewer.scrolled = len(rows)
+
+	visibleRows := w.Bounds.H/step + 2
+	first := w.Scrollbar.Y / step
+	if first > len(rows)-visibleRows {
+		first = len(rows) - visibleRows
+	}
+	if first < 0 {
+		first = 0
+	}
+	last := first + visibleRows
+	if last > len(rows) {
+		last = len(rows)
+	}
+
+	if first > 0 {
+		w.RowScaled(first*step - w.WindowStyle().Spacing.Y).Dynamic(1)
+		w.Spacing(1)
+	}
+	for _, row := range rows[first:last] {
+		switch {
+		case row.line == nil:
+			w.RowScaled(rowHeight).Ratio(0.05, 0.95)
+			w.Spacing(1)
+			viewer.drawText(w, row.detail, 0, 0)
+		case viewer.structured && row.line.Fields != nil:
+			viewer.drawColumns(w, rowHeight, *row.line, columns)
+		default:
+			w.RowScaled(rowHeight).Dynamic(1)
+			viewer.drawText(w, row.line.String(), row.line.Color, len(row.line.Prefix))
+		}
+	}
+	if rest := len(rows) - last; rest > 0 {
+		w.RowScaled(rest*step - w.WindowStyle().Spacing.Y).Dynamic(1)
+		w.Spacing(1)
+	}
+}
+
+// drawColumns shows a JSON line as a toggle for its detail view followed by
+// the chosen columns, the last of which takes the remaining width.
+func (viewer *logViewer) drawColumns(w *nucular.Window, rowHeight int, line viewLine, columns []string) {
+	values, _ := logColumns(line.Fields, columns)
+	ratios := []float64{0.05}
+	width := 0.95
+	if line.Prefix != "" {
+		ratios = append(ratios, 0.2)
+		width -= 0.2
+	}
+	for i := range values {
+		if i == len(values)-1 {
+			ratios = append(ratios, width)
+		} else {
+			ratios = append(ratios, width*0.25)
+			width -= width * 0.25
+		}
+	}
+	w.RowScaled(rowHeight).Ratio(ratios...)
+	toggle := "+"
+	if viewer.expanded[line.ID] {
+		toggle = "-"
+	}
+	if w.ButtonText(toggle) {
+		viewer.expanded[line.ID] = !viewer.expanded[line.ID]
+	}
+	if line.Prefix != "" {
+		viewer.drawText(w, line.Prefix, line.Color, len(line.Prefix))
+	}
+	for _, value := range values {
+		viewer.drawText(w, value, 0, 0)
+	}
+}
+
+// drawText draws text as a label, behind which the first prefixLength bytes
+// get the color numbered color and search matches are highlighted.
+func (viewer *logViewer) drawText(w *nucular.Window, text string, color, prefixLength int) {
+	masterStyle := w.Master().Style()
+	bounds := w.WidgetBounds()
+	x := bounds.X + masterStyle.Text.Padding.X
+	if color > 0 && prefixLength > 0 {
+		w.Commands().FillRect(rect.Rect{
+			X: x,
+			Y: bounds.Y,
+			W: nucular.FontWidth(masterStyle.Font, text[:prefixLength]),
+			H: bounds.H,
+		}, 0, logPrefixColors[(color-1)%len(logPrefixColors)])
+	}
+	if viewer.searchRegexp != nil {
+		for _, match := range viewer.searchRegexp.FindAllStringIndex(text, -1) {
+			w.Commands().FillRect(rect.Rect{
+				X: x + nucular.FontWidth(masterStyle.Font, text[:match[0]]),
+				Y: bounds.Y,
+				W: nucular.FontWidth(masterStyle.Font, text[match[0]:match[1]]),
+				H: bounds.H,
+			}, 0, logHighlight)
+		}
+	}
+	w.Label(text, "LC")
+}
+
+func saveLogLines(path string, lines []viewLine) error {
+	if path == "" {
+		return errors.New("no file to save the logs to")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.WriteString(line.String() + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -1,14 +1,14 @@
 package kubectl
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"log"
-	"os/exec"
 	"strings"
 
 	"github.com/brettcodling/Kubessh/pkg/notify"
 	"github.com/brettcodling/systray"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type Namespace struct {
@@ -28,42 +28,41 @@ func AddNamespaces() {
 }
 
 func getCurrentNamespace() *Namespace {
-	currentContext := getCurrentContext()
-	if currentContext.Namespace == "" {
+	name := getCurrentContext().Namespace
+	if isolated && sessionContext != "" {
+		name = sessionNamespace
+	}
+	if name == "" {
 		return &Namespace{}
 	}
 
 	for _, namespace := range Namespaces {
-		if namespace.Name == currentContext.Namespace {
+		if namespace.Name == name {
 			return namespace
 		}
 	}
 
 	return &Namespace{
-		Name:  currentContext.Namespace,
+		Name:  name,
 		InUse: true,
 	}
 }
 
 func GetNamespaces() []*Namespace {
-	Namespaces = []*Namespace{}
 	currentNamespace := getCurrentNamespace().Name
-	cmd := "kubectl get namespaces -o name"
-	rawNamespaces, err := exec.Command("bash", "-c", cmd).Output()
+	Namespaces = []*Namespace{}
+	names, err := getBackend().ListNamespaces(context.Background())
 	if err != nil {
 		log.Println(err)
 		notify.Warning("ERROR!", err.Error())
 
 		return Namespaces
 	}
-	scanner := bufio.NewScanner(strings.NewReader(string(rawNamespaces)))
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		namespace := Namespace{
-			Name: scanner.Text()[10:],
-		}
-		namespace.InUse = namespace.Name == currentNamespace
-		Namespaces = append(Namespaces, &namespace)
+	for _, name := range names {
+		Namespaces = append(Namespaces, &Namespace{
+			Name:  name,
+			InUse: name == currentNamespace,
+		})
 	}
 
 	return Namespaces
@@ -94,13 +93,18 @@ func SetNamespaces() {
 				case <-namespace.ClickedCh:
 					if !n.InUse {
 						go func(n *Namespace) {
-							n.Use()
+							if err := n.Use(); err != nil {
+								log.Println(err)
+								notify.Warning("ERROR!", err.Error())
+								return
+							}
 							for _, namespaceItem := range namespaceMenuItems {
 								namespaceItem.Title = strings.TrimLeft(namespaceItem.Title, "* ")
 								namespaceItem.Item.SetTitle(namespaceItem.Title)
 							}
 							namespace.SetTitle("* " + n.Name)
 							namespacesMenuItem.SetTitle("Namespace: " + n.Name)
+							restartPodWatch()
 						}(n)
 					}
 				}
@@ -113,23 +117,30 @@ func SetNamespaces() {
 }
 
 func (namespace Namespace) Use() error {
+	if err := validateNamespaceName(namespace.Name); err != nil {
+		return err
+	}
 	currentNamespace := getCurrentNamespace()
 	if namespace.Name == currentNamespace.Name {
 		return nil
 	}
 
-	cmd := "kubectl config set-context --current --namespace \"" + namespace.Name + "\""
-	rawResult, err := exec.Command("bash", "-c", cmd).CombinedOutput()
-	result := strings.TrimSpace(string(rawResult))
-	if len(result) < 9 || result[:7] != "Context" || result[len(result)-9:] != "modified." {
-		if len(result) >= 5 && result[:5] == "error" {
-			return errors.New(result)
+	if isolated {
+		selectSession(getCurrentContext().Name, namespace.Name)
+	} else {
+		err := modifyKubeconfig(func(config *clientcmdapi.Config) error {
+			c, ok := config.Contexts[config.CurrentContext]
+			if !ok {
+				return errors.New("no current context is set")
+			}
+			c.Namespace = namespace.Name
+			return nil
+		})
+		if err != nil {
+			return err
 		}
+		GetContexts()
 	}
-	if err != nil {
-		return err
-	}
-	GetContexts()
 
 	for key, n := range Namespaces {
 		if n.InUse {
@@ -141,5 +152,7 @@ func (namespace Namespace) Use() error {
 		Namespaces[key] = n
 	}
 
+	publishContext()
+
 	return nil
 }
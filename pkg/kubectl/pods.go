@@ -1,93 +1,98 @@
 package kubectl
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aarzilli/nucular"
 	"github.com/aarzilli/nucular/style"
-	"github.com/brettcodling/Kubessh/pkg/database"
 	"github.com/brettcodling/Kubessh/pkg/notify"
-	"github.com/brettcodling/systray"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type Pod struct {
-	Name       string
-	Ready      string
-	Status     string
-	Restarts   string
-	Age        string
-	Containers []Container
-	CreatedAt  time.Time
+	Name   string `json:"name"`
+	Ready  string `json:"ready"`
+	Status string `json:"status"`
+	// Phase is the pod's phase, such as Running, while Status is the
+	// reason shown to the user, such as a container's CrashLoopBackOff.
+	Phase      string      `json:"phase"`
+	Restarts   string      `json:"restarts"`
+	Age        string      `json:"age"`
+	Containers []Container `json:"containers"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	// Workload is the pod's owner as kind/name, such as deploy/api, or
+	// pod/<name> when it has none. It stays the same across rollouts.
+	Workload string            `json:"workload"`
+	Labels   map[string]string `json:"labels,omitempty"`
 }
 
 type Container struct {
-	Name  string
-	Image string
-	Ready string
+	Name  string          `json:"name"`
+	Image string          `json:"image"`
+	Ready string          `json:"ready"`
+	Ports []ContainerPort `json:"ports,omitempty"`
+}
+
+type ContainerPort struct {
+	Name     string `json:"name,omitempty"`
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
 }
 
 var (
-	currentPod          Pod
-	currentOpenPod      nucular.MasterWindow
-	currentOpenPods     nucular.MasterWindow
-	pods                []*Pod
-	podUpdateCh         chan string
-	portForwardCancel   map[string]context.CancelFunc
-	portForwarding      map[string]MenuItem
-	portForwardMenuItem *systray.MenuItem
-	portForwardOpen     bool
-
-	portFrom, portTo             nucular.TextEditor
-	portFromString, portToString string
+	currentPod      Pod
+	currentOpenPod  nucular.MasterWindow
+	currentOpenPods nucular.MasterWindow
+	pods            []*Pod
+	portForwardOpen bool
+
+	tailSelector nucular.TextEditor
 
 	selectedContainer int
 )
 
 func init() {
-	portForwarding = make(map[string]MenuItem)
-	portForwardCancel = make(map[string]context.CancelFunc)
-	portFrom.Flags = nucular.EditField
-	portFrom.SingleLine = true
-	portTo.Flags = nucular.EditField
-	portTo.SingleLine = true
-}
-
-func AddPortForwarding() {
-	portForwardMenuItem = systray.AddMenuItem("Port Forwarding:", "")
-	portForwardMenuItem.Hide()
+	tailSelector.Flags = nucular.EditField
+	tailSelector.SingleLine = true
 }
 
 func getPods() {
-	pods = []*Pod{}
-	cmd := "kubectl get pods --no-headers"
-	rawPods, err := exec.Command("bash", "-c", cmd).Output()
-	if err != nil {
+	if err := loadPods(context.Background()); err != nil {
 		log.Println(err)
 		notify.Warning("ERROR!", err.Error())
+		podsMu.Lock()
+		pods = []*Pod{}
+		podsMu.Unlock()
+		podsChanged()
+	}
+}
 
-		return
+// loadPods replaces the pod list, unless listing fails or ctx is done by the
+// time the list arrives.
+func loadPods(ctx context.Context) error {
+	newPods, err := getBackend().ListPods(ctx)
+	if err != nil {
+		return err
 	}
-	scanner := bufio.NewScanner(strings.NewReader(string(rawPods)))
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		columns := strings.Fields(scanner.Text())
-		pod := Pod{
-			Name:     columns[0],
-			Ready:    columns[1],
-			Status:   columns[2],
-			Restarts: columns[3],
-			Age:      columns[4],
-		}
-		pod.getContainers()
-		pods = append(pods, &pod)
+	sort.Slice(newPods, func(i, j int) bool {
+		return newPods[i].Name < newPods[j].Name
+	})
+	podsMu.Lock()
+	if ctx.Err() != nil {
+		podsMu.Unlock()
+		return nil
 	}
+	pods = newPods
+	podsMu.Unlock()
+	podsChanged()
+
+	return nil
 }
 
 func OpenPods() {
@@ -95,14 +100,37 @@ func OpenPods() {
 		currentOpenPods.Close()
 	}
 	getPods()
-	watchPods()
+	release := watchPods()
+	defer release()
 	currentOpenPods = nucular.NewMasterWindow(0, "Pods: "+getCurrentContext().Name, updatePods)
 	currentOpenPods.SetStyle(style.FromTheme(style.DarkTheme, 2.0))
 	currentOpenPods.Main()
 }
 
 func updatePods(w *nucular.Window) {
-	for _, pod := range pods {
+	w.Row(30).Ratio(0.3, 0.5, 0.2)
+	w.Label("Tail selector:", "LC")
+	tailSelector.Edit(w)
+	if w.ButtonText("Tail") {
+		go func(selector string) {
+			if err := viewTail(TailTarget{Selector: selector}); err != nil {
+				log.Println(err)
+				notify.Warning("ERROR!", err.Error())
+			}
+		}(strings.TrimSpace(string(tailSelector.Buffer)))
+	}
+	w.Row(30).Ratio(0.3, 0.5, 0.2)
+	w.Label("Forward to (svc/name):", "LC")
+	forwardTargetEditor.Edit(w)
+	if w.ButtonText("Open") {
+		go func(target string) {
+			if err := OpenForwardTarget(target); err != nil {
+				log.Println(err)
+				notify.Warning("ERROR!", err.Error())
+			}
+		}(strings.TrimSpace(string(forwardTargetEditor.Buffer)))
+	}
+	for _, pod := range getPodList() {
 		w.Row(30).Dynamic(1)
 		podOpen := w.TreePush(nucular.TreeNode, pod.Name, false)
 		if podOpen {
@@ -111,7 +139,7 @@ func updatePods(w *nucular.Window) {
 			w.Spacing(1)
 			if w.ButtonText(">>") {
 				go func(pod Pod) {
-					currentPod = pod
+					setCurrentPod(pod)
 					openPod()
 				}(*pod)
 			}
@@ -120,18 +148,34 @@ func updatePods(w *nucular.Window) {
 	}
 }
 
+// OpenPod opens the window of the named pod in the current namespace without
+// waiting for it to be closed.
+func OpenPod(name string) error {
+	if err := validatePodName(name); err != nil {
+		return err
+	}
+	pod, err := getBackend().GetPod(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	go func() {
+		setCurrentPod(*pod)
+		openPod()
+	}()
+
+	return nil
+}
+
 func openPod() {
 	if currentOpenPod != nil {
 		currentOpenPod.Close()
 	}
-	portFromString = database.Get("PORT-FROM-" + currentPod.Name)
-	portFrom.SelectAll()
-	portFrom.Text([]rune(portFromString))
-	portToString = database.Get("PORT-TO-" + currentPod.Name)
-	portTo.SelectAll()
-	portTo.Text([]rune(portToString))
+	pod := getCurrentPod()
+	loadPodForwardForm(pod)
 	selectedContainer = 0
-	currentOpenPod = nucular.NewMasterWindow(0, "Pod: "+currentPod.Name, updatePod)
+	shellKey = ""
+	logOptionsKey = ""
+	currentOpenPod = nucular.NewMasterWindow(0, "Pod: "+pod.Name, updatePod)
 	currentOpenPod.SetStyle(style.FromTheme(style.DarkTheme, 2.0))
 	currentOpenPod.Main()
 }
@@ -146,28 +190,30 @@ func podDetails(w *nucular.Window, pod Pod) {
 }
 
 func updatePod(w *nucular.Window) {
+	pod := getCurrentPod()
 	w.Row(40).Dynamic(1)
 	w.Label("Details", "LC")
 	w.Row(30).Dynamic(2)
-	podDetails(w, currentPod)
-	if len(currentPod.Containers) > 0 {
+	podDetails(w, pod)
+	if len(pod.Containers) > 0 {
 		w.Row(10).Dynamic(1)
 		w.Row(30).Dynamic(2)
 		w.Label("Containers:", "LC")
 		var containers []string
-		for _, container := range currentPod.Containers {
+		for _, container := range pod.Containers {
 			containers = append(containers, container.Name)
 		}
 		selectedContainer = w.ComboSimple(containers, selectedContainer, 20)
+		container := pod.Containers[selectedContainer].Name
 		w.Row(30).Dynamic(2)
 		w.Label("Image:", "LC")
-		w.Label(currentPod.Containers[selectedContainer].Image, "LC")
+		w.Label(pod.Containers[selectedContainer].Image, "LC")
 		w.Row(30).Dynamic(2)
 		w.Label("Ready:", "LC")
-		w.Label(currentPod.Containers[selectedContainer].Ready, "LC")
+		w.Label(pod.Containers[selectedContainer].Ready, "LC")
 		if w.ButtonText("SSH") {
 			go func() {
-				err := currentPod.ssh(currentPod.Containers[selectedContainer].Name)
+				err := pod.ssh(container)
 				if err != nil {
 					log.Println(err)
 					notify.Warning("ERROR!", err.Error())
@@ -176,69 +222,60 @@ func updatePod(w *nucular.Window) {
 		}
 		if w.ButtonText("Logs") {
 			go func() {
-				err := currentPod.logs(currentPod.Containers[selectedContainer].Name)
+				err := pod.logs(container)
+				if err != nil {
+					log.Println(err)
+					notify.Warning("ERROR!", err.Error())
+				}
+			}()
+		}
+		w.Row(30).Dynamic(2)
+		if w.ButtonText("Log Viewer") {
+			go func() {
+				err := pod.viewLogs(container)
 				if err != nil {
 					log.Println(err)
 					notify.Warning("ERROR!", err.Error())
 				}
 			}()
 		}
+		if w.ButtonText("Tail " + pod.Workload) {
+			go func(workload string) {
+				if err := viewTail(TailTarget{Workload: workload}); err != nil {
+					log.Println(err)
+					notify.Warning("ERROR!", err.Error())
+				}
+			}(pod.Workload)
+		}
+	}
+	if len(pod.Containers) > 0 {
+		w.Row(40).Dynamic(1)
+		shellOpen = w.TreePush(nucular.TreeNode, "Shell", false)
+		if shellOpen {
+			updateShellPreference(w, pod.Containers[selectedContainer])
+			w.TreePop()
+		}
+		w.Row(40).Dynamic(1)
+		logOptionsOpen = w.TreePush(nucular.TreeNode, "Log Options", false)
+		if logOptionsOpen {
+			updateLogOptions(w, pod, pod.Containers[selectedContainer].Name)
+			w.TreePop()
+		}
+		w.Row(40).Dynamic(1)
+		recordOpen = w.TreePush(nucular.TreeNode, "Record Logs", false)
+		if recordOpen {
+			updateRecording(w, pod, pod.Containers[selectedContainer].Name)
+			w.TreePop()
+		}
 	}
 	w.Row(40).Dynamic(1)
 	portForwardOpen = w.TreePush(nucular.TreeNode, "Port Forwarding", false)
 	if portForwardOpen {
-		updatePortForward(w)
+		updatePodPortForward(w)
 		w.TreePop()
 	}
 }
 
-func updatePortForward(w *nucular.Window) {
-	if portFromString != string(portFrom.Buffer) {
-		portFromString = string(portFrom.Buffer)
-		database.Set("PORT-FROM-"+currentPod.Name, portFromString)
-		cancelPortForwarding(currentPod.Name)
-	}
-	if portToString != string(portTo.Buffer) {
-		portToString = string(portTo.Buffer)
-		database.Set("PORT-TO-"+currentPod.Name, portToString)
-		cancelPortForwarding(currentPod.Name)
-	}
-	w.Row(30).Dynamic(2)
-	w.Label("From:", "LC")
-	portFrom.Edit(w)
-	w.Label("To:", "LC")
-	portTo.Edit(w)
-	w.Row(30).Dynamic(2)
-	w.Spacing(1)
-	if _, ok := portForwarding[currentPod.Name]; ok {
-		if w.ButtonText("Stop") {
-			cancelPortForwarding(currentPod.Name)
-		}
-	} else {
-		if w.ButtonText("Start") {
-			var ctx context.Context
-			ctx, portForwardCancel[currentPod.Name] = context.WithCancel(context.Background())
-			go func() {
-				currentPod.portForward(ctx)
-			}()
-			portForwardMenuItem.Show()
-			menuItem := portForwardMenuItem.AddSubMenuItem(currentPod.Name, "")
-			go func(p Pod) {
-				for {
-					select {
-					case <-menuItem.ClickedCh:
-						cancelPortForwarding(p.Name)
-					}
-				}
-			}(currentPod)
-			portForwarding[currentPod.Name] = MenuItem{
-				Item:  menuItem,
-				Title: currentPod.Name,
-			}
-		}
-	}
-}
-
 func (pod Pod) getName() string {
 	return fmt.Sprintf("%s %s Age: %s", pod.Name, pod.Ready, pod.Age)
 }
@@ -268,164 +305,115 @@ func (pod Pod) getAge() string {
 	return age
 }
 
-type PodData struct {
-	Metadata PodMetadata `json:"metadata"`
-	Spec     PodSpec     `json:"spec"`
-	Status   PodStatus   `json:"status"`
-}
-
-type PodMetadata struct {
-	CreationTimestamp string `json:"creationTimestamp"`
-}
-
-type PodSpec struct {
-	Containers []PodContainer `json:"containers"`
-}
-
-type PodStatus struct {
-	ContainerStatuses []PodContainerStatus `json:"containerStatuses"`
-}
-
-type PodContainer struct {
-	Name  string `json:"name"`
-	Image string `json:"image"`
-}
+func (pod Pod) ssh(container string) error {
+	if err := validatePodTarget(pod.Name, container); err != nil {
+		return err
+	}
+	shell, err := shellCommand(context.Background(), pod, container)
+	if err != nil {
+		return err
+	}
+	command := getBackend().ExecCommand(pod.Name, container, shell)
 
-type PodContainerStatus struct {
-	Name  string `json:"name"`
-	Ready bool   `json:"ready"`
+	return openSession("SSH: "+pod.Name+" "+container, "ssh "+pod.Name+"/"+container, command)
 }
 
-func (pod *Pod) getContainers() error {
-	cmd := fmt.Sprintf("kubectl get pods %s -o json", pod.Name)
-	rawPod, err := exec.Command("bash", "-c", cmd).Output()
-	if err != nil {
+func (pod Pod) logs(container string) error {
+	if err := validatePodTarget(pod.Name, container); err != nil {
 		return err
 	}
-	var podData PodData
-	err = json.Unmarshal(rawPod, &podData)
-	if err != nil {
+	if err := validateTail(tailString); err != nil {
 		return err
 	}
-	for _, container := range podData.Spec.Containers {
-		ready := "false"
-		for _, containerStatus := range podData.Status.ContainerStatuses {
-			if containerStatus.Name == container.Name {
-				if containerStatus.Ready {
-					ready = "true"
-				}
-			}
-		}
-		pod.Containers = append(pod.Containers, Container{
-			Name:  container.Name,
-			Image: container.Image,
-			Ready: ready,
-		})
-	}
-	pod.CreatedAt, err = time.ParseInLocation(time.RFC3339, podData.Metadata.CreationTimestamp, time.UTC)
+	opts, err := pod.logOptions(container)
 	if err != nil {
 		return err
 	}
+	command := getBackend().LogsCommand(opts)
 
-	return nil
+	return openSession("Logs: "+pod.Name+" "+container, "logs "+pod.Name+"/"+container, command)
 }
 
-func watchPods() {
-	if podUpdateCh != nil {
-		return
+func newPod(p *corev1.Pod) *Pod {
+	pod := &Pod{
+		Name:      p.Name,
+		CreatedAt: p.CreationTimestamp.Time.UTC(),
+		Labels:    p.Labels,
 	}
-	podUpdateCh = make(chan string)
-	go func() {
-		var last string
-		tick := time.Tick(1 * time.Second)
-		for {
-			select {
-			case <-tick:
-				rawPodNames, err := exec.Command("bash", "-c", fmt.Sprintf("kubectl get pods -o jsonpath='{.items[*].metadata.name}'")).Output()
-				if err != nil {
-					log.Println(err)
-					notify.Warning("ERROR!", err.Error())
-
-					return
-				}
-				if last == "" {
-					last = string(rawPodNames)
-				} else {
-					if last != string(rawPodNames) {
-						last = string(rawPodNames)
-						podUpdateCh <- string(rawPodNames)
-					}
-				}
-			}
+	ready := 0
+	var restarts int32
+	for _, container := range p.Spec.Containers {
+		c := Container{
+			Name:  container.Name,
+			Image: container.Image,
+			Ready: "false",
 		}
-	}()
-	go func() {
-		for {
-			select {
-			case podNamesString := <-podUpdateCh:
-				podNames := strings.Split(podNamesString, " ")
-				currentPodExists := false
-				newPods := false
-				missingPods := false
-				if !newPods {
-					for _, pod := range podNames {
-						isNew := true
-						for _, existingPod := range pods {
-							if existingPod.Name == pod {
-								isNew = false
-								break
-							}
-						}
-						newPods = isNew
-						if newPods || currentPodExists {
-							break
-						}
-					}
-				}
-				if !missingPods {
-					for _, pod := range pods {
-						missing := true
-						for _, podName := range podNames {
-							if pod.Name == podName {
-								missing = false
-								break
-							}
-						}
-						missingPods = missing
-						if missingPods {
-							break
-						}
-					}
-				}
-				if newPods || missingPods || !currentPodExists {
-					getPods()
+		for _, port := range container.Ports {
+			c.Ports = append(c.Ports, ContainerPort{
+				Name:     port.Name,
+				Port:     port.ContainerPort,
+				Protocol: string(port.Protocol),
+			})
+		}
+		for _, status := range p.Status.ContainerStatuses {
+			if status.Name == container.Name {
+				if status.Ready {
+					c.Ready = "true"
+					ready++
 				}
+				restarts += status.RestartCount
 			}
 		}
-	}()
-}
-
-func (pod Pod) ssh(container string) error {
-	return exec.Command("xterm", "-title", "SSH: "+currentPod.Name+" "+container, "-geometry", getWindowGeometry(), "-e", "kubectl exec -it -c "+container+" "+pod.Name+" -- bash").Run()
+		pod.Containers = append(pod.Containers, c)
+	}
+	pod.Ready = fmt.Sprintf("%d/%d", ready, len(p.Spec.Containers))
+	pod.Restarts = strconv.Itoa(int(restarts))
+	pod.Status = podStatus(p)
+	pod.Phase = string(p.Status.Phase)
+	pod.Age = pod.getAge()
+	pod.Workload = podWorkload(p)
+
+	return pod
 }
 
-func (pod Pod) logs(container string) error {
-	return exec.Command("xterm", "-title", "Logs: "+currentPod.Name+" "+container, "-geometry", getWindowGeometry(), "-e", "kubectl logs -f --tail="+tailString+" --timestamps=true -c "+container+" "+pod.Name).Run()
-}
+func podWorkload(p *corev1.Pod) string {
+	for _, owner := range p.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		switch owner.Kind {
+		case "ReplicaSet":
+			if hash := p.Labels["pod-template-hash"]; hash != "" {
+				return "deploy/" + strings.TrimSuffix(owner.Name, "-"+hash)
+			}
+			return "rs/" + owner.Name
+		case "StatefulSet":
+			return "sts/" + owner.Name
+		case "DaemonSet":
+			return "ds/" + owner.Name
+		default:
+			return strings.ToLower(owner.Kind) + "/" + owner.Name
+		}
+	}
 
-func (pod Pod) portForward(ctx context.Context) {
-	exec.CommandContext(ctx, "bash", "-c", fmt.Sprintf("kubectl port-forward %s %s:%s", currentPod.Name, portFromString, portToString)).Run()
+	return "pod/" + p.Name
 }
 
-func cancelPortForwarding(name string) {
-	if _, ok := portForwarding[currentPod.Name]; ok {
-		if cancelFunc, ok := portForwardCancel[name]; ok {
-			cancelFunc()
-		}
-		portForwarding[name].Item.Remove()
-		delete(portForwarding, name)
-		if len(portForwarding) < 1 {
-			portForwardMenuItem.Hide()
+func podStatus(p *corev1.Pod) string {
+	status := string(p.Status.Phase)
+	if p.Status.Reason != "" {
+		status = p.Status.Reason
+	}
+	for _, containerStatus := range p.Status.ContainerStatuses {
+		if containerStatus.State.Waiting != nil && containerStatus.State.Waiting.Reason != "" {
+			status = containerStatus.State.Waiting.Reason
+		} else if containerStatus.State.Terminated != nil && containerStatus.State.Terminated.Reason != "" {
+			status = containerStatus.State.Terminated.Reason
 		}
 	}
+	if p.DeletionTimestamp != nil {
+		status = "Terminating"
+	}
+
+	return status
 }
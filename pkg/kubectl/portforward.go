@@ -0,0 +1,429 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brettcodling/Kubessh/pkg/database"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+	"github.com/brettcodling/systray"
+)
+
+// PortForward describes a port-forward started from the tray. It keeps
+// running against the cluster it was started in, given by its
+// ClusterTarget, whatever the tray selects later. ID identifies it as
+// context/namespace/kind/name. Current is the pod being forwarded to once
+// Pod has been replaced by another pod of its Workload.
+type PortForward struct {
+	ID       string        `json:"id"`
+	Pod      string        `json:"pod"`
+	Mappings []PortMapping `json:"mappings"`
+	ClusterTarget
+	Workload string `json:"workload,omitempty"`
+	Current  string `json:"current,omitempty"`
+	State    string `json:"state,omitempty"`
+}
+
+// PortMapping forwards local port From to remote port To.
+type PortMapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (mapping PortMapping) String() string {
+	return mapping.From + ":" + mapping.To
+}
+
+func (mapping PortMapping) describe() string {
+	return "localhost:" + mapping.From + " to " + mapping.To
+}
+
+// ParsePortMapping parses FROM:TO, or just PORT to use the same port on both
+// ends.
+func ParsePortMapping(value string) (PortMapping, error) {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok {
+		from, to = value, value
+	}
+	mapping := PortMapping{From: from, To: to}
+
+	return mapping, validatePortMapping(from, to)
+}
+
+func joinPortMappings(mappings []PortMapping) string {
+	values := []string{}
+	for _, mapping := range mappings {
+		values = append(values, mapping.String())
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// forwardID identifies target, a pod name or kind/name such as svc/api, in
+// cluster as context/namespace/kind/name.
+func forwardID(cluster ClusterTarget, target string) string {
+	kind, name, ok := strings.Cut(target, "/")
+	if !ok {
+		kind, name = "pod", target
+	}
+	if short, ok := forwardKind(kind); ok {
+		kind = short
+	}
+
+	return cluster.Context + "/" + cluster.Namespace + "/" + kind + "/" + name
+}
+
+// ForwardID identifies target in the selected context and namespace.
+func ForwardID(target string) string {
+	return forwardID(currentClusterTarget(), target)
+}
+
+func getSavedPortForward(id string) (SavedPortForward, bool) {
+	var saved SavedPortForward
+	value := database.Get("FORWARD-PORTS-" + id)
+	if value == "" {
+		return saved, false
+	}
+	if err := json.Unmarshal([]byte(value), &saved); err != nil {
+		log.Println(err)
+		return saved, false
+	}
+
+	return saved, true
+}
+
+func savePortForward(saved SavedPortForward) error {
+	if len(saved.Mappings) == 0 {
+		return database.Delete("FORWARD-PORTS-" + saved.ID)
+	}
+	value, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	return database.Set("FORWARD-PORTS-"+saved.ID, string(value))
+}
+
+// MigratePortForwards moves the ports saved under PORT-FROM- and PORT-TO-
+// keys for a bare pod name to a saved forward keyed by the context,
+// namespace and target. Those settings are assumed to belong to the selected
+// context and namespace.
+func MigratePortForwards() {
+	cluster := currentClusterTarget()
+	targets := make(map[string]bool)
+	for _, prefix := range []string{"PORT-FROM-", "PORT-TO-"} {
+		for target := range database.List(prefix) {
+			targets[target] = true
+		}
+	}
+	for target := range targets {
+		// An empty from let kubectl pick a port, which was never shown, so
+		// the remote port is used locally too.
+		to := strings.TrimSpace(database.Get("PORT-TO-" + target))
+		from := strings.TrimSpace(database.Get("PORT-FROM-" + target))
+		if from == "" {
+			from = to
+		}
+		id := forwardID(cluster, target)
+		if _, ok := getSavedPortForward(id); !ok && to != "" {
+			saved := SavedPortForward{ID: id, Pod: target, ClusterTarget: cluster, Mappings: []PortMapping{{From: from, To: to}}}
+			if err := savePortForward(saved); err != nil {
+				log.Println(err)
+				continue
+			}
+		}
+		for _, prefix := range []string{"PORT-FROM-", "PORT-TO-"} {
+			database.Delete(prefix + target)
+		}
+	}
+}
+
+var (
+	portForwardMu       sync.Mutex
+	portForwardCancel   map[string]context.CancelFunc
+	portForwarding      map[string]MenuItem
+	portForwardPorts    map[string]PortForward
+	portForwardChildren map[string][]*systray.MenuItem
+	portForwardMenuItem *systray.MenuItem
+
+	restoreMu              sync.Mutex
+	restoreItems           = make(map[string]*systray.MenuItem)
+	restoreForwardMenuItem *systray.MenuItem
+)
+
+func init() {
+	portForwarding = make(map[string]MenuItem)
+	portForwardCancel = make(map[string]context.CancelFunc)
+	portForwardPorts = make(map[string]PortForward)
+	portForwardChildren = make(map[string][]*systray.MenuItem)
+}
+
+func AddPortForwarding() {
+	portForwardMenuItem = systray.AddMenuItem("Port Forwarding:", "")
+	portForwardMenuItem.Hide()
+	restoreForwardMenuItem = systray.AddMenuItem("Restore Port Forwards", "Forwards that were running when Kubessh last stopped")
+	restoreForwardMenuItem.Hide()
+	forget := restoreForwardMenuItem.AddSubMenuItem("Forget All", "")
+	go func() {
+		for range forget.ClickedCh {
+			forgetPortForwards()
+		}
+	}()
+
+	events, _ := Subscribe()
+	go func() {
+		for event := range events {
+			if event.Type == EventContext {
+				retitleForwards()
+			}
+		}
+	}()
+}
+
+func savedActivePortForwards() []PortForward {
+	forwards := []PortForward{}
+	for _, value := range database.List("FORWARD-ACTIVE-") {
+		var forward PortForward
+		if err := json.Unmarshal([]byte(value), &forward); err != nil {
+			log.Println(err)
+			continue
+		}
+		forwards = append(forwards, forward)
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].ID < forwards[j].ID
+	})
+
+	return forwards
+}
+
+// RestorePortForwards restarts the forwards that were running when Kubessh
+// last stopped, each in the context and namespace it was started in. Those
+// that fail are listed under Restore Port Forwards to be tried again.
+func RestorePortForwards() {
+	for _, forward := range savedActivePortForwards() {
+		if isPortForwarding(forward.ID) {
+			continue
+		}
+		if err := restorePortForward(forward); err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", "Unable to restore port forward for "+forward.Pod+": "+err.Error())
+			offerPortForward(forward)
+		}
+	}
+}
+
+func restorePortForward(forward PortForward) error {
+	pod, _, err := forward.resolve(context.Background(), forward.Current)
+	if err != nil {
+		return err
+	}
+	forward.Current, forward.State = pod, ""
+	if err := startForward(forward); err != nil {
+		return err
+	}
+	restoreMu.Lock()
+	defer restoreMu.Unlock()
+	if item, ok := restoreItems[forward.ID]; ok {
+		item.Remove()
+		delete(restoreItems, forward.ID)
+	}
+	if len(restoreItems) < 1 {
+		restoreForwardMenuItem.Hide()
+	}
+
+	return nil
+}
+
+// offerPortForward lists forward under Restore Port Forwards.
+func offerPortForward(forward PortForward) {
+	restoreMu.Lock()
+	defer restoreMu.Unlock()
+	if _, ok := restoreItems[forward.ID]; ok {
+		return
+	}
+	title := forward.Namespace + "/" + forward.Pod + " " + joinPortMappings(forward.Mappings)
+	item := restoreForwardMenuItem.AddSubMenuItem(title, "Context: "+forward.Context)
+	restoreItems[forward.ID] = item
+	restoreForwardMenuItem.Show()
+	go func() {
+		for range item.ClickedCh {
+			if err := restorePortForward(forward); err != nil {
+				log.Println(err)
+				notify.Warning("ERROR!", "Unable to restore port forward for "+forward.Pod+": "+err.Error())
+				continue
+			}
+			return
+		}
+	}()
+}
+
+func forgetPortForwards() {
+	restoreMu.Lock()
+	defer restoreMu.Unlock()
+	for name, item := range restoreItems {
+		item.Remove()
+		delete(restoreItems, name)
+		if err := database.Delete("FORWARD-ACTIVE-" + name); err != nil {
+			log.Println(err)
+		}
+	}
+	restoreForwardMenuItem.Hide()
+}
+
+func isPortForwarding(id string) bool {
+	portForwardMu.Lock()
+	defer portForwardMu.Unlock()
+	_, ok := portForwarding[id]
+
+	return ok
+}
+
+// startPortForwarding forwards target in the selected context and namespace.
+func startPortForwarding(target string, mappings []PortMapping) error {
+	return startForward(PortForward{Pod: target, Mappings: mappings})
+}
+
+// startForward starts forward in its cluster, or in the selected context and
+// namespace when it has none.
+func startForward(forward PortForward) error {
+	if err := validateForwardTarget(forward.Pod); err != nil {
+		return invalid(err)
+	}
+	if err := validatePortMappings(forward.Mappings); err != nil {
+		return invalid(err)
+	}
+	if forward.ClusterTarget == (ClusterTarget{}) {
+		forward.ClusterTarget = currentClusterTarget()
+	}
+	forward.ID = forwardID(forward.ClusterTarget, forward.Pod)
+	id := forward.ID
+	portForwardMu.Lock()
+	defer portForwardMu.Unlock()
+	if _, ok := portForwarding[id]; ok {
+		return fmt.Errorf("%s is already being forwarded", id)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	portForwardCancel[id] = cancel
+	go superviseForward(ctx, forward)
+	portForwardMenuItem.Show()
+	menuItem := portForwardMenuItem.AddSubMenuItem(forwardTitle(forward), "Context: "+forward.Context)
+	children := []*systray.MenuItem{}
+	for _, mapping := range forward.Mappings {
+		child := menuItem.AddSubMenuItem(mapping.describe(), "")
+		child.Disable()
+		children = append(children, child)
+	}
+	stop := menuItem.AddSubMenuItem("Stop", "")
+	portForwardChildren[id] = append(children, stop)
+	go func() {
+		for {
+			select {
+			case <-stop.ClickedCh:
+				cancelPortForwarding(id)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	portForwarding[id] = MenuItem{
+		Item:  menuItem,
+		Title: forwardTitle(forward),
+	}
+	portForwardPorts[id] = forward
+	// The forward stays saved until stopped, so that quitting or a crash
+	// leaves it to be restored on the next launch.
+	saveActivePortForward(forward)
+	publish(Event{Type: EventPortForwardStarted, Context: forward.Context, Namespace: forward.Namespace, Pod: forward.Pod})
+
+	return nil
+}
+
+func saveActivePortForward(forward PortForward) {
+	value, err := json.Marshal(forward)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := database.Set("FORWARD-ACTIVE-"+forward.ID, string(value)); err != nil {
+		log.Println(err)
+	}
+}
+
+func cancelPortForwarding(id string) {
+	portForwardMu.Lock()
+	defer portForwardMu.Unlock()
+	if _, ok := portForwarding[id]; ok {
+		if cancelFunc, ok := portForwardCancel[id]; ok {
+			cancelFunc()
+		}
+		children := portForwardChildren[id]
+		for i := len(children) - 1; i >= 0; i-- {
+			children[i].Remove()
+		}
+		portForwarding[id].Item.Remove()
+		forward := portForwardPorts[id]
+		delete(portForwardChildren, id)
+		delete(portForwarding, id)
+		delete(portForwardCancel, id)
+		delete(portForwardPorts, id)
+		if err := database.Delete("FORWARD-ACTIVE-" + id); err != nil {
+			log.Println(err)
+		}
+		if len(portForwarding) < 1 {
+			portForwardMenuItem.Hide()
+		}
+		publish(Event{Type: EventPortForwardStopped, Context: forward.Context, Namespace: forward.Namespace, Pod: forward.Pod})
+	}
+}
+
+// ActivePortForwards lists the port-forwards the tray is running.
+func ActivePortForwards() []PortForward {
+	portForwardMu.Lock()
+	defer portForwardMu.Unlock()
+	forwards := []PortForward{}
+	for _, forward := range portForwardPorts {
+		forwards = append(forwards, forward)
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].ID < forwards[j].ID
+	})
+
+	return forwards
+}
+
+// StartPortForward starts forwarding to pod in the selected context and
+// namespace. Without mappings it forwards the ones saved for the pod, in
+// the context they were saved in.
+func StartPortForward(pod string, mappings []PortMapping) error {
+	if len(mappings) > 0 {
+		return startPortForwarding(pod, mappings)
+	}
+	saved, err := GetSavedPortForward(pod)
+	if err != nil {
+		return err
+	}
+
+	return startForward(PortForward{Pod: saved.Pod, Mappings: saved.Mappings, ClusterTarget: saved.ClusterTarget})
+}
+
+// StopPortForward stops the forward with the given ID, or of the given pod
+// in the selected context and namespace.
+func StopPortForward(pod string) error {
+	id := pod
+	if !isPortForwarding(id) {
+		id = ForwardID(pod)
+	}
+	if !isPortForwarding(id) {
+		return fmt.Errorf("port forward for %s %w", pod, ErrNotFound)
+	}
+	cancelPortForwarding(id)
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Command is a single kubectl invocation. Stdout and Stderr are optional
+// writers that receive output as it is produced, which is needed for long
+// running commands such as port-forward.
+type Command struct {
+	Args    []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Timeout time.Duration
+}
+
+// Result holds the captured output of a Command. Stdout is only captured when
+// the Command has no Stdout writer of its own.
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Runner executes kubectl commands.
+type Runner interface {
+	Run(ctx context.Context, cmd Command) (Result, error)
+}
+
+// RunError is returned when kubectl exits unsuccessfully.
+type RunError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (e *RunError) Error() string {
+	if e.Stderr != "" {
+		return e.Stderr
+	}
+
+	return e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+const defaultTimeout = 30 * time.Second
+
+var runner Runner = ExecRunner{Binary: "kubectl"}
+
+// SetRunner replaces the runner used for every kubectl invocation.
+func SetRunner(r Runner) {
+	runner = r
+}
+
+// ExecRunner runs commands with a kubectl binary.
+type ExecRunner struct {
+	Binary string
+}
+
+func (r ExecRunner) Run(ctx context.Context, cmd Command) (Result, error) {
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, r.Binary, cmd.Args...)
+	c.Stdin = cmd.Stdin
+	c.Stdout = &stdout
+	if cmd.Stdout != nil {
+		c.Stdout = cmd.Stdout
+	}
+	c.Stderr = &stderr
+	if cmd.Stderr != nil {
+		c.Stderr = io.MultiWriter(&stderr, cmd.Stderr)
+	}
+
+	err := c.Run()
+	result := Result{
+		Stdout: stdout.Bytes(),
+		Stderr: stderr.Bytes(),
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return result, &RunError{
+			Args:   cmd.Args,
+			Stderr: strings.TrimSpace(stderr.String()),
+			Err:    err,
+		}
+	}
+
+	return result, nil
+}
+
+// kubectlArgs prefixes args with the flags every kubectl invocation needs to
+// target the selected kubeconfig, context and namespace.
+func kubectlArgs(args ...string) []string {
+	global := []string{}
+	if activeKubeconfig != "" {
+		global = append(global, "--kubeconfig="+activeKubeconfig)
+	}
+	global = append(global, targetArgs()...)
+
+	return append(global, args...)
+}
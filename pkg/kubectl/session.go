@@ -0,0 +1,61 @@
+package kubectl
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// Exec runs command in a container attached to the current terminal, in raw
+// mode when stdin is a terminal. Terminal windows opened by the tray run it
+// through the CLI when the API backend is selected, since there is no
+// kubectl binary to run instead.
+func Exec(ctx context.Context, pod, container string, command []string) error {
+	if err := validatePodTarget(pod, container); err != nil {
+		return err
+	}
+	opts := ExecOptions{
+		Pod:       pod,
+		Container: container,
+		Command:   command,
+		Stdin:     os.Stdin,
+		Stdout:    os.Stdout,
+		Stderr:    os.Stderr,
+	}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+		opts.TTY = true
+		sizes := make(chan TerminalSize, 1)
+		resize := make(chan os.Signal, 1)
+		signal.Notify(resize, syscall.SIGWINCH)
+		defer signal.Stop(resize)
+		go func() {
+			defer close(sizes)
+			for {
+				if width, height, err := term.GetSize(fd); err == nil {
+					select {
+					case sizes <- TerminalSize{Width: uint16(width), Height: uint16(height)}:
+					case <-ctx.Done():
+						return
+					}
+				}
+				select {
+				case <-resize:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		opts.TerminalSize = sizes
+	}
+
+	return getBackend().Exec(ctx, opts)
+}
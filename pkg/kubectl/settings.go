@@ -1,14 +1,28 @@
 package kubectl
 
 import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
 	"github.com/aarzilli/nucular"
 	"github.com/aarzilli/nucular/style"
 	"github.com/brettcodling/Kubessh/pkg/database"
+	"github.com/brettcodling/Kubessh/pkg/notify"
 )
 
 var (
-	windowWidth, windowHeight, tail                   nucular.TextEditor
-	windowWidthString, windowHeightString, tailString string
+	windowWidth, windowHeight, tail, kubeconfigs, shells nucular.TextEditor
+	terminalTemplateEditor, tmuxSession                  nucular.TextEditor
+	windowWidthString, windowHeightString, tailString    string
+
+	backendString    string
+	selectedBackend  int
+	selectedTerminal int
+	selectedSession  int
+	isolatedSetting  bool
+	trayPodsSetting  bool
 )
 
 func init() {
@@ -32,6 +46,44 @@ func init() {
 	}
 	tail.Flags = nucular.EditField
 	tail.SingleLine = true
+
+	kubeconfigs.Flags = nucular.EditBox
+	shells.Flags = nucular.EditField
+	shells.SingleLine = true
+
+	terminalString = database.Get("TERMINAL")
+	if terminalString == "" {
+		terminalString = "xterm"
+	}
+	terminalTemplateString = database.Get("TERMINAL_TEMPLATE")
+	if terminalTemplateString == "" {
+		terminalTemplateString = terminalPresets["xterm"]
+	}
+	terminalTemplateEditor.Flags = nucular.EditField
+	terminalTemplateEditor.SingleLine = true
+
+	sessionModeString = database.Get("SESSION_MODE")
+	if sessionModeString == "" {
+		sessionModeString = SessionTerminal
+	}
+	tmuxSessionString = database.Get("TMUX_SESSION")
+	if tmuxSessionString == "" {
+		tmuxSessionString = "kubessh"
+	}
+	tmuxSession.Flags = nucular.EditField
+	tmuxSession.SingleLine = true
+
+	recordDirString = database.Get("RECORD_DIR")
+	recordMaxSizeString = database.Get("RECORD_MAX_SIZE")
+	if recordMaxSizeString == "" {
+		recordMaxSizeString = defaultRecordMaxSize
+	}
+	recordGzip = database.Get("RECORD_GZIP") == "1"
+
+	backendString = database.Get("BACKEND")
+	if backendString == "" {
+		backendString = BackendKubectl
+	}
 }
 
 func getWindowGeometry() string {
@@ -45,6 +97,39 @@ func OpenSettings() {
 	windowHeight.Text([]rune(windowHeightString))
 	tail.SelectAll()
 	tail.Text([]rune(tailString))
+	shells.SelectAll()
+	shells.Text([]rune(shellsString))
+	kubeconfigs.SelectAll()
+	kubeconfigs.Text([]rune(strings.Join(kubeconfigPaths, "\n")))
+	terminalTemplateEditor.SelectAll()
+	terminalTemplateEditor.Text([]rune(terminalTemplateString))
+	selectedTerminal = 0
+	for i, terminal := range Terminals {
+		if terminal == terminalString {
+			selectedTerminal = i
+		}
+	}
+	tmuxSession.SelectAll()
+	tmuxSession.Text([]rune(tmuxSessionString))
+	selectedSession = 0
+	for i, mode := range SessionModes {
+		if mode == sessionModeString {
+			selectedSession = i
+		}
+	}
+	recordDir.SelectAll()
+	recordDir.Text([]rune(recordDirectory()))
+	recordMaxSize.SelectAll()
+	recordMaxSize.Text([]rune(recordMaxSizeString))
+	recordGzipSetting = recordGzip
+	isolatedSetting = isolated
+	trayPodsSetting = trayPodsEnabled()
+	selectedBackend = 0
+	for i, backend := range Backends {
+		if backend == backendString {
+			selectedBackend = i
+		}
+	}
 	wnd := nucular.NewMasterWindow(0, "Settings", updateSettings)
 	wnd.SetStyle(style.FromTheme(style.DarkTheme, 2.0))
 	wnd.Main()
@@ -60,18 +145,129 @@ func updateSettings(w *nucular.Window) {
 	w.Label("Height:", "LC")
 	windowHeight.Edit(w)
 	w.Row(40).Dynamic(1)
+	w.Label("Terminal:", "LC")
+	w.Row(30).Dynamic(2)
+	w.Label("Emulator:", "LC")
+	selectedTerminal = w.ComboSimple(Terminals, selectedTerminal, 20)
+	if Terminals[selectedTerminal] == TerminalCustom {
+		w.Row(30).Dynamic(1)
+		w.Label("Template ({title}, {geometry}, {width}, {height}, {command}):", "LC")
+		w.Row(30).Dynamic(1)
+		terminalTemplateEditor.Edit(w)
+	}
+	w.Row(30).Dynamic(2)
+	w.Label("Open sessions in:", "LC")
+	selectedSession = w.ComboSimple(SessionModes, selectedSession, 20)
+	if SessionModes[selectedSession] != SessionTerminal {
+		w.Row(30).Dynamic(2)
+		w.Label("tmux session:", "LC")
+		tmuxSession.Edit(w)
+	}
+	w.Row(30).Dynamic(2)
+	w.Label("Shells to try:", "LC")
+	shells.Edit(w)
+	w.Row(40).Dynamic(1)
 	w.Label("Logs:", "LC")
 	w.Row(30).Dynamic(2)
 	w.Label("Tail:", "LC")
 	tail.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Record to:", "LC")
+	recordDir.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Rotate files at (MB):", "LC")
+	recordMaxSize.Edit(w)
+	w.Row(30).Dynamic(1)
+	w.CheckboxText("Compress rotated files with gzip", &recordGzipSetting)
+	w.Row(40).Dynamic(1)
+	w.Label("Cluster:", "LC")
+	w.Row(30).Dynamic(2)
+	w.Label("Backend:", "LC")
+	selectedBackend = w.ComboSimple(Backends, selectedBackend, 20)
+	w.Row(30).Dynamic(1)
+	w.CheckboxText("Isolated session (leave the kubeconfig's context untouched)", &isolatedSetting)
+	w.Row(30).Dynamic(1)
+	w.CheckboxText("Show pods in the tray menu", &trayPodsSetting)
+	w.Row(30).Dynamic(1)
+	w.Label("Kubeconfig files (one per line, empty for default):", "LC")
+	w.Row(90).Dynamic(1)
+	kubeconfigs.Edit(w)
 	w.Row(30).Dynamic(1)
 	if w.ButtonText("Save") {
+		if err := validateSettings(); err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", err.Error())
+			return
+		}
 		windowWidthString = string(windowWidth.Buffer)
 		database.Set("WINDOW_WIDTH", windowWidthString)
 		windowHeightString = string(windowHeight.Buffer)
 		database.Set("WINDOW_HEIGHT", windowHeightString)
 		tailString = string(tail.Buffer)
 		database.Set("TAIL", tailString)
+		recordDirString = strings.TrimSpace(string(recordDir.Buffer))
+		database.Set("RECORD_DIR", recordDirString)
+		recordMaxSizeString = string(recordMaxSize.Buffer)
+		database.Set("RECORD_MAX_SIZE", recordMaxSizeString)
+		recordGzip = recordGzipSetting
+		gzipValue := ""
+		if recordGzip {
+			gzipValue = "1"
+		}
+		database.Set("RECORD_GZIP", gzipValue)
+		shellsString = strings.Join(strings.Fields(string(shells.Buffer)), " ")
+		if shellsString == "" {
+			shellsString = defaultShells
+		}
+		database.Set("SHELLS", shellsString)
+		terminalString = Terminals[selectedTerminal]
+		database.Set("TERMINAL", terminalString)
+		terminalTemplateString = string(terminalTemplateEditor.Buffer)
+		database.Set("TERMINAL_TEMPLATE", terminalTemplateString)
+		sessionModeString = SessionModes[selectedSession]
+		database.Set("SESSION_MODE", sessionModeString)
+		tmuxSessionString = string(tmuxSession.Buffer)
+		database.Set("TMUX_SESSION", tmuxSessionString)
+		backendString = Backends[selectedBackend]
+		database.Set("BACKEND", backendString)
+		paths := splitKubeconfigPaths(string(kubeconfigs.Buffer))
+		database.Set("KUBECONFIGS", strings.Join(paths, "\n"))
+		setKubeconfigPaths(paths)
+		setIsolated(isolatedSetting)
+		setTrayPods(trayPodsSetting)
+		go func() {
+			SetContexts()
+			SetNamespaces()
+			restartPodWatch()
+			restartLogAlerts()
+		}()
 		w.Master().Close()
 	}
 }
+
+func validateSettings() error {
+	for _, size := range []string{string(windowWidth.Buffer), string(windowHeight.Buffer)} {
+		if number, err := strconv.Atoi(size); err != nil || number < 1 {
+			return fmt.Errorf("invalid window size %q: must be a positive number", size)
+		}
+	}
+
+	if err := validateShellPreference(ShellPreference{Shells: strings.Fields(string(shells.Buffer))}); err != nil {
+		return err
+	}
+	if err := validateRecordSettings(strings.TrimSpace(string(recordDir.Buffer)), string(recordMaxSize.Buffer)); err != nil {
+		return err
+	}
+	if Terminals[selectedTerminal] == TerminalCustom {
+		if err := validateTerminalTemplate(string(terminalTemplateEditor.Buffer)); err != nil {
+			return err
+		}
+	}
+	if SessionModes[selectedSession] != SessionTerminal {
+		if err := validateTmuxSession(string(tmuxSession.Buffer)); err != nil {
+			return err
+		}
+	}
+
+	return validateTail(string(tail.Buffer))
+}
@@ -0,0 +1,266 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/brettcodling/Kubessh/pkg/database"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const defaultShells = "bash sh ash"
+
+// ShellPreference is how exec sessions start in a container. It is saved per
+// container name or per image repository, and a container preference wins
+// over one for its image.
+type ShellPreference struct {
+	Shells  []string `json:"shells,omitempty"`
+	Workdir string   `json:"workdir,omitempty"`
+	Env     []string `json:"env,omitempty"`
+}
+
+var (
+	shellsString string
+
+	// detectedShells caches probe results by cluster, pod and container,
+	// which are replaced together whenever the image changes.
+	detectedShellsMu sync.Mutex
+	detectedShells   = make(map[detectedShellKey]string)
+
+	shellOpen                                 bool
+	shellScope                                int
+	shellKey                                  string
+	shellShellsEditor, shellWorkdir, shellEnv nucular.TextEditor
+)
+
+func init() {
+	shellsString = database.Get("SHELLS")
+	if shellsString == "" {
+		shellsString = defaultShells
+	}
+	for _, editor := range []*nucular.TextEditor{&shellShellsEditor, &shellWorkdir, &shellEnv} {
+		editor.Flags = nucular.EditField
+		editor.SingleLine = true
+	}
+}
+
+// imageRepository strips the tag and digest so a preference survives image
+// updates.
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image
+}
+
+func shellPreferenceKey(container Container, byImage bool) string {
+	if byImage {
+		return "SHELL-IMAGE-" + imageRepository(container.Image)
+	}
+
+	return "SHELL-CONTAINER-" + container.Name
+}
+
+func loadShellPreference(key string) ShellPreference {
+	var preference ShellPreference
+	if value := database.Get(key); value != "" {
+		if err := json.Unmarshal([]byte(value), &preference); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return preference
+}
+
+func saveShellPreference(key string, preference ShellPreference) error {
+	if err := validateShellPreference(preference); err != nil {
+		return err
+	}
+	if len(preference.Shells) == 0 && preference.Workdir == "" && len(preference.Env) == 0 {
+		return database.Delete(key)
+	}
+	value, err := json.Marshal(preference)
+	if err != nil {
+		return err
+	}
+
+	return database.Set(key, string(value))
+}
+
+func getShellPreference(container Container) ShellPreference {
+	preference := loadShellPreference(shellPreferenceKey(container, false))
+	image := loadShellPreference(shellPreferenceKey(container, true))
+	if len(preference.Shells) == 0 {
+		preference.Shells = image.Shells
+	}
+	if preference.Workdir == "" {
+		preference.Workdir = image.Workdir
+	}
+	if len(preference.Env) == 0 {
+		preference.Env = image.Env
+	}
+
+	return preference
+}
+
+type detectedShellKey struct {
+	ClusterTarget
+	pod, container string
+}
+
+// exitedNonZero reports whether err is the probed command exiting with a
+// non-zero status, which is how a missing shell shows up, rather than the
+// exec failing to reach the container at all. kubectl exits 1 for both, but
+// only says the command terminated when it ran.
+func exitedNonZero(err error) bool {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return true
+	}
+	var runErr *RunError
+
+	return errors.As(err, &runErr) && strings.Contains(runErr.Stderr, "command terminated with exit code")
+}
+
+// detectShell returns the first of shells that runs in the container.
+func detectShell(ctx context.Context, pod, container string, shells []string) (string, error) {
+	target := currentClusterTarget()
+	key := detectedShellKey{target, pod, container}
+	detectedShellsMu.Lock()
+	shell, ok := detectedShells[key]
+	detectedShellsMu.Unlock()
+	if ok {
+		return shell, nil
+	}
+
+	backend := backendFor(target)
+	for _, shell := range shells {
+		probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := backend.Exec(probeCtx, ExecOptions{
+			Pod:       pod,
+			Container: container,
+			Command:   []string{shell, "-c", "exit 0"},
+			Stdout:    io.Discard,
+			Stderr:    io.Discard,
+		})
+		cancel()
+		if err == nil {
+			detectedShellsMu.Lock()
+			detectedShells[key] = shell
+			detectedShellsMu.Unlock()
+			return shell, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if !exitedNonZero(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("no shell found in %s/%s (tried %s)", pod, container, strings.Join(shells, ", "))
+}
+
+// shellCommand picks a shell for the container and returns the command that
+// starts it with the container's preferred working directory and
+// environment.
+func shellCommand(ctx context.Context, pod Pod, container string) ([]string, error) {
+	preference := ShellPreference{}
+	for _, c := range pod.Containers {
+		if c.Name == container {
+			preference = getShellPreference(c)
+		}
+	}
+	shells := preference.Shells
+	if len(shells) == 0 {
+		shells = strings.Fields(shellsString)
+	}
+	if err := validateShellPreference(ShellPreference{Shells: shells, Workdir: preference.Workdir, Env: preference.Env}); err != nil {
+		return nil, err
+	}
+	shell, err := detectShell(ctx, pod.Name, container, shells)
+	if err != nil {
+		return nil, err
+	}
+	if preference.Workdir == "" && len(preference.Env) == 0 {
+		return []string{shell}, nil
+	}
+	workdir := preference.Workdir
+	if workdir == "" {
+		workdir = "."
+	}
+
+	// The values are passed as positional arguments rather than written into
+	// the script, so they are never parsed by the shell.
+	command := []string{shell, "-c", `cd "$1" || exit 1; shift; for kv do export "$kv"; done; exec "$0"`, shell, workdir}
+
+	return append(command, preference.Env...), nil
+}
+
+// ShellCommand is shellCommand for a pod looked up by name.
+func ShellCommand(ctx context.Context, name, container string) ([]string, error) {
+	pod, err := getBackend().GetPod(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if container == "" && len(pod.Containers) > 0 {
+		container = pod.Containers[0].Name
+	}
+
+	return shellCommand(ctx, *pod, container)
+}
+
+// updateShellPreference edits the shell preference of the selected container
+// in the pod window.
+func updateShellPreference(w *nucular.Window, container Container) {
+	w.Row(30).Dynamic(2)
+	w.Label("Save for:", "LC")
+	shellScope = w.ComboSimple([]string{"container " + container.Name, "image " + imageRepository(container.Image)}, shellScope, 20)
+	key := shellPreferenceKey(container, shellScope == 1)
+	if key != shellKey {
+		shellKey = key
+		preference := loadShellPreference(key)
+		shellShellsEditor.SelectAll()
+		shellShellsEditor.Text([]rune(strings.Join(preference.Shells, " ")))
+		shellWorkdir.SelectAll()
+		shellWorkdir.Text([]rune(preference.Workdir))
+		shellEnv.SelectAll()
+		shellEnv.Text([]rune(strings.Join(preference.Env, " ")))
+	}
+	w.Row(30).Dynamic(2)
+	w.Label("Shells (default "+shellsString+"):", "LC")
+	shellShellsEditor.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Working directory:", "LC")
+	shellWorkdir.Edit(w)
+	w.Row(30).Dynamic(2)
+	w.Label("Environment (NAME=VALUE ...):", "LC")
+	shellEnv.Edit(w)
+	w.Row(30).Dynamic(1)
+	if w.ButtonText("Save Shell") {
+		err := saveShellPreference(key, ShellPreference{
+			Shells:  strings.Fields(string(shellShellsEditor.Buffer)),
+			Workdir: strings.TrimSpace(string(shellWorkdir.Buffer)),
+			Env:     strings.Fields(string(shellEnv.Buffer)),
+		})
+		if err != nil {
+			log.Println(err)
+			notify.Warning("ERROR!", err.Error())
+			return
+		}
+		detectedShellsMu.Lock()
+		detectedShells = make(map[detectedShellKey]string)
+		detectedShellsMu.Unlock()
+	}
+}
@@ -0,0 +1,45 @@
+package kubectl
+
+import (
+	"context"
+	"testing"
+)
+
+// A shell that exits non-zero is skipped, but an exec that never reached the
+// container is returned rather than reported as no shell found.
+func TestDetectShell(t *testing.T) {
+	fake := NewFakeRunner()
+	previous := runner
+	previousOverride := backendOverride
+	t.Cleanup(func() {
+		SetRunner(previous)
+		backendOverride = previousOverride
+		detectedShellsMu.Lock()
+		detectedShells = make(map[detectedShellKey]string)
+		detectedShellsMu.Unlock()
+	})
+	SetRunner(fake)
+	backendOverride = testKubectlBackend()
+	probe := func(pod, shell string) []string {
+		return testKubectlBackend().args("exec", "-i", "-c", "app", pod, "--", shell, "-c", "exit 0")
+	}
+	fake.Record(Recording{Args: probe("api", "bash"), Stderr: "OCI runtime exec failed: exec: \"bash\": executable file not found in $PATH: unknown\ncommand terminated with exit code 126\n", Error: "exit status 126"})
+	fake.Record(Recording{Args: probe("api", "sh")})
+	fake.Record(Recording{Args: probe("db-0", "bash"), Stderr: "Unable to connect to the server: dial tcp 10.0.0.1:6443: i/o timeout\n", Error: "exit status 1"})
+
+	if shell, err := detectShell(context.Background(), "api", "app", []string{"bash", "sh"}); err != nil || shell != "sh" {
+		t.Errorf("detectShell(api) = %q, %v, want sh", shell, err)
+	}
+	calls := len(fake.Calls)
+	if shell, err := detectShell(context.Background(), "api", "app", []string{"bash", "sh"}); err != nil || shell != "sh" || len(fake.Calls) != calls {
+		t.Errorf("cached detectShell(api) = %q, %v after %d probes", shell, err, len(fake.Calls)-calls)
+	}
+
+	shell, err := detectShell(context.Background(), "db-0", "app", []string{"bash", "sh"})
+	if err == nil || err.Error() != "Unable to connect to the server: dial tcp 10.0.0.1:6443: i/o timeout" {
+		t.Errorf("detectShell(db-0) = %q, %v, want the connection error", shell, err)
+	}
+	if last := fake.Calls[len(fake.Calls)-1]; last[len(last)-3] != "bash" {
+		t.Errorf("probed %q after the connection error", last)
+	}
+}
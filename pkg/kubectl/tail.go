@@ -0,0 +1,293 @@
+package kubectl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// tailReorderWindow is how long merged lines are held back so that lines
+// arriving late from a slower stream can still be put in time order.
+const tailReorderWindow = time.Second
+
+// TailTarget selects the pods to tail, by label selector or by the workload
+// that owns them (see Pod.Workload). Exactly one is set.
+type TailTarget struct {
+	Selector string
+	Workload string
+}
+
+func (target TailTarget) String() string {
+	if target.Workload != "" {
+		return target.Workload
+	}
+
+	return "-l " + target.Selector
+}
+
+// TailLine is one line from a tailed container. Color numbers the pod so
+// that each pod keeps its own prefix color.
+type TailLine struct {
+	Pod       string
+	Container string
+	Time      time.Time
+	Text      string
+	Color     int
+
+	received time.Time
+}
+
+func (line TailLine) Prefix() string {
+	return "[" + line.Pod + "/" + line.Container + "]"
+}
+
+func validateTailTarget(target TailTarget) (labels.Selector, error) {
+	if (target.Selector == "") == (target.Workload == "") {
+		return nil, fmt.Errorf("tail needs either a label selector or a workload")
+	}
+	if target.Workload != "" {
+		kind, name, ok := strings.Cut(target.Workload, "/")
+		if !ok || kind == "" {
+			return nil, fmt.Errorf("invalid workload %q: must be kind/name such as deploy/api", target.Workload)
+		}
+		if err := validatePodName(name); err != nil {
+			return nil, fmt.Errorf("invalid workload %q: %w", target.Workload, err)
+		}
+		return nil, nil
+	}
+	selector, err := labels.Parse(target.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", target.Selector, err)
+	}
+
+	return selector, nil
+}
+
+type tailStream struct {
+	cancel context.CancelFunc
+	last   time.Time
+	done   bool
+}
+
+// tailer streams the logs of every container of the pods matching a target,
+// starting and stopping streams as pods come and go.
+type tailer struct {
+	ctx      context.Context
+	target   TailTarget
+	selector labels.Selector
+	tail     string
+	since    time.Time
+	emit     func(TailLine)
+
+	mu      sync.Mutex
+	streams map[string]*tailStream
+	colors  map[string]int
+	pending []TailLine
+}
+
+// Tail follows the logs of the pods matching target and calls emit with
+// their lines merged in time order, until ctx is done. tail is how many
+// earlier lines to show from each container.
+func Tail(ctx context.Context, target TailTarget, tail string, emit func(TailLine)) error {
+	if tail != "" {
+		if err := validateTail(tail); err != nil {
+			return err
+		}
+	}
+
+	return tailLogs(ctx, target, tail, time.Time{}, emit)
+}
+
+// TailSince is Tail showing the lines logged after since instead of a number
+// of earlier lines, including all of those from containers that start later.
+func TailSince(ctx context.Context, target TailTarget, since time.Time, emit func(TailLine)) error {
+	return tailLogs(ctx, target, "", since, emit)
+}
+
+func tailLogs(ctx context.Context, target TailTarget, tail string, since time.Time, emit func(TailLine)) error {
+	selector, err := validateTailTarget(target)
+	if err != nil {
+		return err
+	}
+	t := &tailer{
+		ctx:      ctx,
+		target:   target,
+		selector: selector,
+		tail:     tail,
+		since:    since,
+		emit:     emit,
+		streams:  make(map[string]*tailStream),
+		colors:   make(map[string]int),
+	}
+	go t.merge(ctx)
+	for {
+		err := getBackend().WatchPods(ctx, t.apply)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (t *tailer) matches(pod *Pod) bool {
+	if t.target.Workload != "" {
+		return pod.Workload == t.target.Workload
+	}
+
+	return t.selector.Matches(labels.Set(pod.Labels))
+}
+
+func (t *tailer) apply(event PodEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pod := event.Pod
+	if event.Type == "DELETED" || !t.matches(pod) {
+		for key, stream := range t.streams {
+			if strings.HasPrefix(key, pod.Name+"/") {
+				stream.cancel()
+				delete(t.streams, key)
+			}
+		}
+		return
+	}
+	// Status shows the worst container, so a crashing sidecar would stop
+	// the others being tailed; the phase stays Running while any runs.
+	if pod.Phase != "Running" {
+		return
+	}
+	if _, ok := t.colors[pod.Name]; !ok {
+		t.colors[pod.Name] = len(t.colors)
+	}
+	for _, container := range pod.Containers {
+		key := pod.Name + "/" + container.Name
+		stream, ok := t.streams[key]
+		if ok && !stream.done {
+			continue
+		}
+		opts := LogOptions{
+			Pod:        pod.Name,
+			Container:  container.Name,
+			Follow:     true,
+			Tail:       t.tail,
+			Timestamps: true,
+		}
+		if !ok {
+			stream = &tailStream{last: t.since}
+			t.streams[key] = stream
+		}
+		if !stream.last.IsZero() {
+			// Carry on from the last line seen after a restart, or from
+			// since.
+			opts.Tail = ""
+			opts.SinceTime = stream.last.Format(time.RFC3339)
+		}
+		ctx, cancel := context.WithCancel(t.ctx)
+		stream.cancel = cancel
+		stream.done = false
+		go t.stream(ctx, stream, opts, stream.last, t.colors[pod.Name])
+	}
+}
+
+// parseTimestampedLine splits a line read with Timestamps into its time and
+// text, leaving stamp zero when it has none. skip is set for lines that are
+// not after resume: SinceTime has second precision, so when a stream is
+// resumed the lines up to the last one seen come back.
+func parseTimestampedLine(line string, resume time.Time) (stamp time.Time, text string, skip bool) {
+	timestamp, text, ok := strings.Cut(line, " ")
+	if !ok {
+		return time.Time{}, line, false
+	}
+	stamp, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return time.Time{}, line, false
+	}
+
+	return stamp, text, !resume.IsZero() && !stamp.After(resume)
+}
+
+// stream reads one container's logs. resume is the last line seen before a
+// restart, or since.
+func (t *tailer) stream(ctx context.Context, stream *tailStream, opts LogOptions, resume time.Time, color int) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(getBackend().Logs(ctx, opts, writer))
+	}()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := TailLine{
+			Pod:       opts.Pod,
+			Container: opts.Container,
+			Time:      time.Now(),
+			Text:      scanner.Text(),
+			Color:     color,
+			received:  time.Now(),
+		}
+		stamp, text, skip := parseTimestampedLine(line.Text, resume)
+		if skip {
+			continue
+		}
+		if !stamp.IsZero() {
+			line.Time, line.Text = stamp, text
+		}
+		t.mu.Lock()
+		stream.last = line.Time
+		t.pending = append(t.pending, line)
+		t.mu.Unlock()
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		log.Println(err)
+	}
+	t.mu.Lock()
+	stream.done = true
+	t.mu.Unlock()
+}
+
+// merge emits pending lines in time order once they have waited out the
+// reorder window. Arrival time decides when a line is ready so that clock
+// skew between the cluster and this machine doesn't hold lines back.
+func (t *tailer) merge(ctx context.Context) {
+	ticker := time.NewTicker(tailReorderWindow / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cutoff := time.Now().Add(-tailReorderWindow)
+		var lines, waiting []TailLine
+		t.mu.Lock()
+		for _, line := range t.pending {
+			if line.received.Before(cutoff) {
+				lines = append(lines, line)
+			} else {
+				waiting = append(waiting, line)
+			}
+		}
+		t.pending = waiting
+		t.mu.Unlock()
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].Time.Before(lines[j].Time)
+		})
+		for _, line := range lines {
+			t.emit(line)
+		}
+	}
+}
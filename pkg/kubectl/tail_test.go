@@ -0,0 +1,30 @@
+package kubectl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestampedLine(t *testing.T) {
+	resume := time.Date(2024, 1, 2, 15, 4, 5, 500000000, time.UTC)
+	tests := []struct {
+		line   string
+		resume time.Time
+		stamp  time.Time
+		text   string
+		skip   bool
+	}{
+		{"2024-01-02T15:04:05.123456789Z started", time.Time{}, time.Date(2024, 1, 2, 15, 4, 5, 123456789, time.UTC), "started", false},
+		{"2024-01-02T15:04:05.123456789Z started", resume, time.Date(2024, 1, 2, 15, 4, 5, 123456789, time.UTC), "started", true},
+		{"2024-01-02T15:04:05.5Z same line", resume, resume, "same line", true},
+		{"2024-01-02T15:04:06Z next", resume, time.Date(2024, 1, 2, 15, 4, 6, 0, time.UTC), "next", false},
+		{"no timestamp here", resume, time.Time{}, "no timestamp here", false},
+		{"unstamped", resume, time.Time{}, "unstamped", false},
+	}
+	for _, test := range tests {
+		stamp, text, skip := parseTimestampedLine(test.line, test.resume)
+		if !stamp.Equal(test.stamp) || text != test.text || skip != test.skip {
+			t.Errorf("parseTimestampedLine(%q, %v) = %v, %q, %v, want %v, %q, %v", test.line, test.resume, stamp, text, skip, test.stamp, test.text, test.skip)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package kubectl
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+const TerminalCustom = "custom"
+
+// Terminal templates are split on whitespace into arguments. {title},
+// {geometry}, {width} and {height} are replaced inside an argument, and an
+// argument that is exactly {command} is replaced by the command's arguments.
+var terminalPresets = map[string]string{
+	"xterm":          "xterm -title {title} -geometry {geometry} -e {command}",
+	"gnome-terminal": "gnome-terminal --wait --title={title} --geometry={geometry} -- {command}",
+	"kitty":          "kitty --title {title} -o initial_window_width={width}c -o initial_window_height={height}c {command}",
+	"alacritty":      "alacritty --title {title} -o window.dimensions.columns={width} -o window.dimensions.lines={height} -e {command}",
+	"wezterm":        "wezterm start --always-new-process -- {command}",
+	"konsole":        "konsole --nofork -p tabtitle={title} -e {command}",
+}
+
+var Terminals = []string{"xterm", "gnome-terminal", "kitty", "alacritty", "wezterm", "konsole", TerminalCustom}
+
+var terminalString, terminalTemplateString string
+
+func terminalTemplate() string {
+	if terminalString == TerminalCustom {
+		return terminalTemplateString
+	}
+	if template, ok := terminalPresets[terminalString]; ok {
+		return template
+	}
+
+	return terminalPresets["xterm"]
+}
+
+func validateTerminalTemplate(template string) error {
+	fields := strings.Fields(template)
+	if len(fields) == 0 {
+		return errors.New("terminal template is empty")
+	}
+	for _, field := range fields {
+		if field == "{command}" {
+			return nil
+		}
+	}
+
+	return errors.New("terminal template must contain {command} as its own argument")
+}
+
+func terminalArgs(template, title string, command []string) []string {
+	replacer := strings.NewReplacer(
+		"{title}", title,
+		"{geometry}", getWindowGeometry(),
+		"{width}", windowWidthString,
+		"{height}", windowHeightString,
+	)
+	args := []string{}
+	for _, field := range strings.Fields(template) {
+		if field == "{command}" {
+			args = append(args, command...)
+			continue
+		}
+		args = append(args, replacer.Replace(field))
+	}
+
+	return args
+}
+
+// launchTerminal runs command in a new terminal window and waits for the
+// window to close.
+func launchTerminal(title string, command []string) error {
+	template := terminalTemplate()
+	if err := validateTerminalTemplate(template); err != nil {
+		return err
+	}
+	args := terminalArgs(template, title, command)
+
+	return exec.Command(args[0], args[1:]...).Run()
+}
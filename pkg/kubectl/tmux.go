@@ -0,0 +1,117 @@
+package kubectl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	SessionTerminal   = "new terminal"
+	SessionTmuxWindow = "tmux window"
+	SessionTmuxPane   = "tmux pane"
+)
+
+var SessionModes = []string{SessionTerminal, SessionTmuxWindow, SessionTmuxPane}
+
+var sessionModeString, tmuxSessionString string
+
+// Panes opened by Kubessh are tagged with this user option so a pod and
+// container can be found again however the window was renamed.
+const tmuxNameOption = "@kubessh"
+
+func validateTmuxSession(name string) error {
+	if name == "" {
+		return errors.New("tmux session name is empty")
+	}
+	if strings.HasPrefix(name, "-") || strings.ContainsAny(name, ":.") {
+		return fmt.Errorf("invalid tmux session name %q: must not start with '-' or contain ':' or '.'", name)
+	}
+
+	return nil
+}
+
+// openSession runs command in a terminal window, or in the configured tmux
+// session when tmux mode is on. name identifies the pod and container so that
+// reopening one selects its existing tmux window.
+func openSession(title, name string, command []string) error {
+	if sessionModeString == SessionTmuxWindow || sessionModeString == SessionTmuxPane {
+		return launchTmux(name, command)
+	}
+
+	return launchTerminal(title, command)
+}
+
+func tmux(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("tmux", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("tmux %s: %s", args[0], message)
+		}
+		return "", fmt.Errorf("tmux %s: %w", args[0], err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func launchTmux(name string, command []string) error {
+	if err := validateTmuxSession(tmuxSessionString); err != nil {
+		return err
+	}
+	target := "=" + tmuxSessionString
+	var pane string
+	if _, err := tmux("has-session", "-t", target); err != nil {
+		args := append([]string{"new-session", "-d", "-s", tmuxSessionString, "-n", name, "-P", "-F", "#{pane_id}", "--"}, command...)
+		if pane, err = tmux(args...); err != nil {
+			return err
+		}
+	} else {
+		panes, err := tmux("list-panes", "-s", "-t", target, "-F", "#{pane_id} #{"+tmuxNameOption+"}")
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(panes, "\n") {
+			id, paneName, _ := strings.Cut(line, " ")
+			if paneName == name {
+				return selectTmuxPane(id)
+			}
+		}
+		args := []string{"new-window", "-t", target + ":", "-n", name, "-P", "-F", "#{pane_id}", "--"}
+		if sessionModeString == SessionTmuxPane {
+			args = []string{"split-window", "-t", target + ":", "-P", "-F", "#{pane_id}", "--"}
+		}
+		if pane, err = tmux(append(args, command...)...); err != nil {
+			return err
+		}
+		if sessionModeString == SessionTmuxPane {
+			tmux("select-layout", "-t", pane, "tiled")
+		}
+	}
+	if _, err := tmux("set-option", "-p", "-t", pane, tmuxNameOption, name); err != nil {
+		return err
+	}
+	if err := selectTmuxPane(pane); err != nil {
+		return err
+	}
+
+	// Nothing is showing the session yet, so open a terminal attached to it.
+	if clients, err := tmux("list-clients", "-t", target); err == nil && clients == "" {
+		return launchTerminal("tmux: "+tmuxSessionString, []string{"tmux", "attach-session", "-t", target})
+	}
+
+	return nil
+}
+
+func selectTmuxPane(pane string) error {
+	if _, err := tmux("select-window", "-t", pane); err != nil {
+		return err
+	}
+	_, err := tmux("select-pane", "-t", pane)
+
+	return err
+}
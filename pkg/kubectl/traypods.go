@@ -0,0 +1,173 @@
+package kubectl
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brettcodling/Kubessh/pkg/database"
+	"github.com/brettcodling/Kubessh/pkg/notify"
+	"github.com/brettcodling/systray"
+)
+
+// trayPod is a pod's entry in the tray's pod submenu.
+type trayPod struct {
+	item       *systray.MenuItem
+	children   []*systray.MenuItem
+	containers string
+	done       chan struct{}
+}
+
+var (
+	trayPodsMu       sync.Mutex
+	trayPodsMenuItem *systray.MenuItem
+	trayPodItems     map[string]*trayPod
+	trayPodsUpdateCh chan struct{}
+	trayPodsRelease  func()
+)
+
+func AddTrayPods() {
+	trayPodsMenuItem = systray.AddMenuItem("Pod Shortcuts", "")
+	trayPodsMenuItem.Hide()
+	trayPodItems = make(map[string]*trayPod)
+	trayPodsUpdateCh = make(chan struct{}, 1)
+	go func() {
+		for range trayPodsUpdateCh {
+			// Let a burst of watch events settle before rebuilding.
+			time.Sleep(500 * time.Millisecond)
+			syncTrayPods()
+		}
+	}()
+	setTrayPods(database.Get("TRAY_PODS") == "1")
+}
+
+func trayPodsEnabled() bool {
+	trayPodsMu.Lock()
+	defer trayPodsMu.Unlock()
+
+	return trayPodsRelease != nil
+}
+
+func setTrayPods(enabled bool) {
+	value := ""
+	if enabled {
+		value = "1"
+	}
+	database.Set("TRAY_PODS", value)
+
+	trayPodsMu.Lock()
+	defer trayPodsMu.Unlock()
+	if trayPodsMenuItem == nil || enabled == (trayPodsRelease != nil) {
+		return
+	}
+	if enabled {
+		trayPodsRelease = watchPods()
+		trayPodsMenuItem.Show()
+		go getPods()
+		return
+	}
+
+	trayPodsRelease()
+	trayPodsRelease = nil
+	for name, entry := range trayPodItems {
+		entry.remove()
+		delete(trayPodItems, name)
+	}
+	trayPodsMenuItem.Hide()
+}
+
+func notifyTrayPods() {
+	if trayPodsUpdateCh == nil {
+		return
+	}
+	select {
+	case trayPodsUpdateCh <- struct{}{}:
+	default:
+	}
+}
+
+func syncTrayPods() {
+	trayPodsMu.Lock()
+	defer trayPodsMu.Unlock()
+	if trayPodsRelease == nil {
+		return
+	}
+
+	current := make(map[string]bool)
+	for _, pod := range getPodList() {
+		current[pod.Name] = true
+		var names []string
+		for _, container := range pod.Containers {
+			names = append(names, container.Name)
+		}
+		containers := strings.Join(names, ",")
+		entry, ok := trayPodItems[pod.Name]
+		if ok && entry.containers != containers {
+			entry.remove()
+			ok = false
+		}
+		if !ok {
+			entry = newTrayPod(*pod, containers)
+			trayPodItems[pod.Name] = entry
+		}
+		entry.item.SetTitle(pod.Name + " (" + pod.Status + " " + pod.Ready + ")")
+	}
+	for name, entry := range trayPodItems {
+		if !current[name] {
+			entry.remove()
+			delete(trayPodItems, name)
+		}
+	}
+	trayPodsMenuItem.SetTitle("Pod Shortcuts: " + getCurrentNamespace().Name)
+}
+
+func newTrayPod(pod Pod, containers string) *trayPod {
+	entry := &trayPod{
+		item:       trayPodsMenuItem.AddSubMenuItem(pod.Name, ""),
+		containers: containers,
+		done:       make(chan struct{}),
+	}
+	for _, container := range pod.Containers {
+		parent := entry.item
+		prefix := ""
+		if len(pod.Containers) > 1 {
+			parent = entry.item.AddSubMenuItem(container.Name, container.Image)
+			entry.children = append(entry.children, parent)
+		} else {
+			prefix = container.Name + ": "
+		}
+		execItem := parent.AddSubMenuItem(prefix+"Exec", "")
+		logsItem := parent.AddSubMenuItem(prefix+"Logs", "")
+		entry.children = append(entry.children, execItem, logsItem)
+		go func(container string) {
+			for {
+				select {
+				case <-execItem.ClickedCh:
+					go runTrayAction(pod.ssh, container)
+				case <-logsItem.ClickedCh:
+					go runTrayAction(pod.logs, container)
+				case <-entry.done:
+					return
+				}
+			}
+		}(container.Name)
+	}
+
+	return entry
+}
+
+func runTrayAction(action func(container string) error, container string) {
+	if err := action(container); err != nil {
+		log.Println(err)
+		notify.Warning("ERROR!", err.Error())
+	}
+}
+
+func (entry *trayPod) remove() {
+	close(entry.done)
+	for i := len(entry.children) - 1; i >= 0; i-- {
+		entry.children[i].Remove()
+	}
+	entry.item.Remove()
+}
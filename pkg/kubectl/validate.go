@@ -0,0 +1,181 @@
+package kubectl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Everything that ends up in a kubectl argument list is checked here first.
+// Arguments are never passed through a shell, but a value starting with "-"
+// would still be read by kubectl as a flag.
+
+// invalidError keeps the message of a validation error while matching
+// ErrInvalid.
+type invalidError struct {
+	error
+}
+
+func (e invalidError) Unwrap() []error {
+	return []error{e.error, ErrInvalid}
+}
+
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return invalidError{err}
+}
+
+func validatePodName(name string) error {
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("invalid pod name %q: %s", name, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+func validateContainerName(name string) error {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid container name %q: %s", name, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+func validateNamespaceName(name string) error {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace %q: %s", name, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// validateContextName accepts the free-form names kubeconfig allows (such as
+// EKS ARNs) but rejects values that could be mistaken for flags.
+func validateContextName(name string) error {
+	if name == "" {
+		return errors.New("context name is empty")
+	}
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid context name %q: must not start with '-'", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("invalid context name %q: contains control characters", name)
+		}
+	}
+
+	return nil
+}
+
+func validatePort(port string) error {
+	number, err := strconv.Atoi(port)
+	if err != nil || len(validation.IsValidPortNum(number)) > 0 || strconv.Itoa(number) != port {
+		return fmt.Errorf("invalid port %q: must be a number between 1 and 65535", port)
+	}
+
+	return nil
+}
+
+// validatePortMapping checks a LOCAL:REMOTE port pair.
+func validatePortMapping(from, to string) error {
+	if err := validatePort(from); err != nil {
+		return err
+	}
+
+	return validatePort(to)
+}
+
+func validatePortMappings(mappings []PortMapping) error {
+	if len(mappings) == 0 {
+		return errors.New("no ports to forward")
+	}
+	from := make(map[string]bool)
+	for _, mapping := range mappings {
+		if err := validatePortMapping(mapping.From, mapping.To); err != nil {
+			return err
+		}
+		if from[mapping.From] {
+			return fmt.Errorf("local port %s is forwarded twice", mapping.From)
+		}
+		from[mapping.From] = true
+	}
+
+	return nil
+}
+
+func validateTail(tail string) error {
+	number, err := strconv.Atoi(tail)
+	if err != nil || number < -1 || strconv.Itoa(number) != tail {
+		return fmt.Errorf("invalid tail %q: must be a number of lines or -1 for all", tail)
+	}
+
+	return nil
+}
+
+func validatePodTarget(pod, container string) error {
+	if err := validatePodName(pod); err != nil {
+		return err
+	}
+	if container == "" {
+		return nil
+	}
+
+	return validateContainerName(container)
+}
+
+func validateShellPreference(preference ShellPreference) error {
+	for _, shell := range preference.Shells {
+		if shell == "" || strings.HasPrefix(shell, "-") {
+			return fmt.Errorf("invalid shell %q: must not be empty or start with '-'", shell)
+		}
+	}
+	if strings.ContainsFunc(preference.Workdir, unicode.IsControl) {
+		return fmt.Errorf("invalid working directory %q: contains control characters", preference.Workdir)
+	}
+	for _, variable := range preference.Env {
+		name, _, ok := strings.Cut(variable, "=")
+		if !ok {
+			return fmt.Errorf("invalid environment variable %q: must be NAME=VALUE", variable)
+		}
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return fmt.Errorf("invalid environment variable %q: %s", variable, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+func validateLogOptions(opts LogOptions) error {
+	if opts.Pod != "" {
+		container := opts.Container
+		if opts.AllContainers {
+			container = ""
+		}
+		if err := validatePodTarget(opts.Pod, container); err != nil {
+			return err
+		}
+	}
+	if opts.Since != "" && opts.SinceTime != "" {
+		return errors.New("only one of since and since time can be set")
+	}
+	if opts.Since != "" {
+		if since, err := time.ParseDuration(opts.Since); err != nil || since <= 0 {
+			return fmt.Errorf("invalid since %q: must be a positive duration such as 30m or 2h", opts.Since)
+		}
+	}
+	if opts.SinceTime != "" {
+		if _, err := time.Parse(time.RFC3339, opts.SinceTime); err != nil {
+			return fmt.Errorf("invalid since time %q: must be an RFC3339 time such as 2024-01-02T15:04:05Z", opts.SinceTime)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var hostileNames = []string{
+	"foo;rm -rf ~",
+	"$(id)",
+	"`id`",
+	"foo'",
+	`foo"`,
+	"foo bar",
+	"foo\nbar",
+	"-n",
+	"--all-namespaces",
+	"Foo",
+	"foo_bar",
+	"foo|cat",
+	"../etc",
+	"",
+}
+
+func TestValidateNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) error
+		valid    []string
+		invalid  []string
+	}{
+		{
+			name:     "pod",
+			validate: validatePodName,
+			valid:    []string{"api", "api-7d9f8b6c5-x2x4q", "db-0", "web.v2", strings.Repeat("a", 253)},
+			invalid:  append([]string{strings.Repeat("a", 254), "api-", "api.", "api..v2"}, hostileNames...),
+		},
+		{
+			name:     "container",
+			validate: validateContainerName,
+			valid:    []string{"app", "side-car", strings.Repeat("a", 63)},
+			invalid:  append([]string{strings.Repeat("a", 64), "app.v2"}, hostileNames...),
+		},
+		{
+			name:     "namespace",
+			validate: validateNamespaceName,
+			valid:    []string{"default", "kube-system", strings.Repeat("n", 63)},
+			invalid:  append([]string{strings.Repeat("n", 64), "team.a"}, hostileNames...),
+		},
+		{
+			name:     "context",
+			validate: validateContextName,
+			// Context names are free-form; they only have to stay one
+			// argument that kubectl won't read as a flag.
+			valid:   []string{"prod", "arn:aws:eks:eu-west-1:123456789012:cluster/shared", "gke_project_zone_name", "foo;rm -rf ~", "$(id)", "Foo"},
+			invalid: []string{"", "-n", "--kubeconfig=/tmp/x", "foo\nbar", "foo\x00bar", "foo\x1b[31m"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range test.valid {
+				if err := test.validate(name); err != nil {
+					t.Errorf("%q rejected: %v", name, err)
+				}
+			}
+			for _, name := range test.invalid {
+				if err := test.validate(name); err == nil {
+					t.Errorf("%q accepted", name)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePort(t *testing.T) {
+	for _, port := range []string{"1", "80", "8080", "65535"} {
+		if err := validatePort(port); err != nil {
+			t.Errorf("validatePort(%q) = %v", port, err)
+		}
+	}
+	for _, port := range []string{"", "0", "-1", "65536", "99999", "80a", " 80", "80 ", "080", "+80", "0x50", "8080;id", "$(id)", "1e3"} {
+		if err := validatePort(port); err == nil {
+			t.Errorf("validatePort(%q) accepted", port)
+		}
+	}
+}
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		value string
+		want  PortMapping
+		valid bool
+	}{
+		{"8080:80", PortMapping{From: "8080", To: "80"}, true},
+		{"8080", PortMapping{From: "8080", To: "8080"}, true},
+		{"8080:", PortMapping{}, false},
+		{":80", PortMapping{}, false},
+		{"8080:0", PortMapping{}, false},
+		{"0:80", PortMapping{}, false},
+		{"8080:80:90", PortMapping{}, false},
+		{"8080:80a", PortMapping{}, false},
+		{"8080: 80", PortMapping{}, false},
+		{"8080:$(id)", PortMapping{}, false},
+		{"", PortMapping{}, false},
+	}
+	for _, test := range tests {
+		mapping, err := ParsePortMapping(test.value)
+		if test.valid && (err != nil || mapping != test.want) {
+			t.Errorf("ParsePortMapping(%q) = %+v, %v, want %+v", test.value, mapping, err, test.want)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("ParsePortMapping(%q) accepted as %+v", test.value, mapping)
+		}
+	}
+}
+
+func TestValidatePortMappings(t *testing.T) {
+	if err := validatePortMappings([]PortMapping{{"8080", "80"}, {"9090", "9090"}}); err != nil {
+		t.Errorf("two mappings rejected: %v", err)
+	}
+	for _, mappings := range [][]PortMapping{
+		nil,
+		{{"8080", "80"}, {"8080", "81"}},
+		{{"8080", "80"}, {"9090", "x"}},
+		{{"", "80"}},
+	} {
+		if err := validatePortMappings(mappings); err == nil {
+			t.Errorf("validatePortMappings(%+v) accepted", mappings)
+		}
+	}
+}
+
+// Rejected forwards are told apart from failures so callers can report bad
+// input, and the message stays the validation error's own.
+func TestStartPortForwardInvalid(t *testing.T) {
+	for _, mappings := range [][]PortMapping{
+		{{"0", "80"}},
+		{{"8080", "80"}, {"8080", "81"}},
+	} {
+		err := StartPortForward("api", mappings)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("StartPortForward(api, %q) = %v, want ErrInvalid", mappings, err)
+		} else if err.Error() != validatePortMappings(mappings).Error() {
+			t.Errorf("StartPortForward(api, %q) = %q", mappings, err)
+		}
+	}
+	if err := StartPortForward("$(id)", []PortMapping{{"8080", "80"}}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("StartPortForward($(id)) = %v, want ErrInvalid", err)
+	}
+}
+
+func TestValidateTail(t *testing.T) {
+	for _, tail := range []string{"-1", "0", "10", "5000"} {
+		if err := validateTail(tail); err != nil {
+			t.Errorf("validateTail(%q) = %v", tail, err)
+		}
+	}
+	for _, tail := range []string{"", "-2", "10a", " 10", "010", "10;id", "all"} {
+		if err := validateTail(tail); err == nil {
+			t.Errorf("validateTail(%q) accepted", tail)
+		}
+	}
+}
+
+func TestValidateLogOptions(t *testing.T) {
+	tests := []struct {
+		opts  LogOptions
+		valid bool
+	}{
+		{LogOptions{Pod: "api", Container: "app"}, true},
+		{LogOptions{Pod: "api", Container: "app", Since: "1h30m"}, true},
+		{LogOptions{Pod: "api", Container: "app", SinceTime: "2024-01-02T15:04:05Z"}, true},
+		{LogOptions{Pod: "api", Container: "$(id)", AllContainers: true}, true},
+		{LogOptions{Pod: "api;id", Container: "app"}, false},
+		{LogOptions{Pod: "api", Container: "-c"}, false},
+		{LogOptions{Pod: "api", Container: "app", Since: "1h", SinceTime: "2024-01-02T15:04:05Z"}, false},
+		{LogOptions{Pod: "api", Container: "app", Since: "-1h"}, false},
+		{LogOptions{Pod: "api", Container: "app", Since: "1h;id"}, false},
+		{LogOptions{Pod: "api", Container: "app", SinceTime: "yesterday"}, false},
+	}
+	for _, test := range tests {
+		err := validateLogOptions(test.opts)
+		if test.valid && err != nil {
+			t.Errorf("validateLogOptions(%+v) = %v", test.opts, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("validateLogOptions(%+v) accepted", test.opts)
+		}
+	}
+}
+
+func TestValidateShellPreference(t *testing.T) {
+	valid := ShellPreference{Shells: []string{"bash", "/bin/sh"}, Workdir: "/srv/app dir", Env: []string{"TERM=xterm", "A_B=1;2"}}
+	if err := validateShellPreference(valid); err != nil {
+		t.Errorf("validateShellPreference(%+v) = %v", valid, err)
+	}
+	for _, preference := range []ShellPreference{
+		{Shells: []string{"-c"}},
+		{Shells: []string{""}},
+		{Workdir: "/srv\nid"},
+		{Env: []string{"TERM"}},
+		{Env: []string{"$(id)=1"}},
+		{Env: []string{"A B=1"}},
+	} {
+		if err := validateShellPreference(preference); err == nil {
+			t.Errorf("validateShellPreference(%+v) accepted", preference)
+		}
+	}
+}
+
+// Whatever a value contains, it reaches kubectl as exactly one argument.
+func TestArgvKeepsValuesWhole(t *testing.T) {
+	contextName := "prod; rm -rf ~ $(id) 'x'"
+	backend := KubectlBackend{Target: &ClusterTarget{Kubeconfig: "/home/me/my configs/kube config", Context: contextName, Namespace: "default"}}
+	got := backend.ExecCommand("api", "app", []string{"sh", "-c", "echo $HOME; id"})
+	want := []string{
+		"kubectl",
+		"--kubeconfig=/home/me/my configs/kube config",
+		"--context=" + contextName,
+		"--namespace=default",
+		"exec", "-it", "-c", "app", "api", "--",
+		"sh", "-c", "echo $HOME; id",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExecCommand() = %q, want %q", got, want)
+	}
+
+	fake := NewFakeRunner()
+	previous := runner
+	SetRunner(fake)
+	t.Cleanup(func() {
+		SetRunner(previous)
+	})
+	backend.PortForward(context.Background(), "api", []string{"8080:80", "9090:9090"})
+	wantCall := []string{"--kubeconfig=/home/me/my configs/kube config", "--context=" + contextName, "--namespace=default", "port-forward", "api", "8080:80", "9090:9090"}
+	if len(fake.Calls) != 1 || !reflect.DeepEqual(fake.Calls[0], wantCall) {
+		t.Errorf("kubectl calls = %q, want %q", fake.Calls, [][]string{wantCall})
+	}
+}
+
+// Hostile input is rejected before kubectl is run at all.
+func TestHostileInputNeverRuns(t *testing.T) {
+	fake := NewFakeRunner()
+	previous := runner
+	SetRunner(fake)
+	t.Cleanup(func() {
+		SetRunner(previous)
+	})
+	backend := testKubectlBackend()
+	for _, name := range hostileNames {
+		if _, err := backend.GetPod(context.Background(), name); err == nil {
+			t.Errorf("GetPod(%q) accepted", name)
+		}
+		if err := backend.PortForward(context.Background(), name, []string{"8080:80"}); err == nil {
+			t.Errorf("PortForward(%q) accepted", name)
+		}
+		if name != "" {
+			if err := backend.Logs(context.Background(), LogOptions{Pod: "api", Container: name}, nil); err == nil {
+				t.Errorf("Logs(container %q) accepted", name)
+			}
+		}
+		if err := backend.Exec(context.Background(), ExecOptions{Pod: name, Container: "app"}); err == nil {
+			t.Errorf("Exec(%q) accepted", name)
+		}
+	}
+	for _, port := range []string{"8080:80;id", "$(id):80", "-p:80", "8080:80 9090"} {
+		if err := backend.PortForward(context.Background(), "api", []string{port}); err == nil {
+			t.Errorf("PortForward(api, %q) accepted", port)
+		}
+	}
+	if len(fake.Calls) != 0 {
+		t.Errorf("kubectl was run with %q", fake.Calls)
+	}
+}
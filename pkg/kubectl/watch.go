@@ -0,0 +1,145 @@
+package kubectl
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	podsMu         sync.Mutex
+	podWatchUsers  int
+	podWatchCancel context.CancelFunc
+)
+
+// watchPods keeps pods up to date from a watch stream for as long as at least
+// one caller holds it. The returned func releases the caller's hold.
+func watchPods() func() {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	podWatchUsers++
+	if podWatchUsers == 1 {
+		startPodWatch()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			podsMu.Lock()
+			defer podsMu.Unlock()
+			podWatchUsers--
+			if podWatchUsers == 0 && podWatchCancel != nil {
+				podWatchCancel()
+				podWatchCancel = nil
+			}
+		})
+	}
+}
+
+// restartPodWatch points a running watch at the current context and
+// namespace.
+func restartPodWatch() {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	if podWatchCancel == nil {
+		return
+	}
+	podWatchCancel()
+	startPodWatch()
+	go getPods()
+}
+
+func startPodWatch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	podWatchCancel = cancel
+	go func() {
+		for {
+			// The stream may still deliver an event or two after a restart
+			// has cancelled ctx, and those belong to the old namespace.
+			err := getBackend().WatchPods(ctx, func(event PodEvent) {
+				applyPodEvent(ctx, event)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			// Reload the whole list, as events may have been missed while
+			// the stream was down. Failures are only logged, as this
+			// repeats for as long as the cluster can't be reached, and the
+			// last list is kept meanwhile.
+			if err := loadPods(ctx); err != nil && ctx.Err() == nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+func applyPodEvent(ctx context.Context, event PodEvent) {
+	podsMu.Lock()
+	if ctx.Err() != nil {
+		podsMu.Unlock()
+		return
+	}
+	index := sort.Search(len(pods), func(i int) bool {
+		return pods[i].Name >= event.Pod.Name
+	})
+	exists := index < len(pods) && pods[index].Name == event.Pod.Name
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		if exists {
+			pods[index] = event.Pod
+		} else {
+			pods = append(pods, nil)
+			copy(pods[index+1:], pods[index:])
+			pods[index] = event.Pod
+		}
+	case "DELETED":
+		if exists {
+			pods = append(pods[:index], pods[index+1:]...)
+		}
+	}
+	if currentPod.Name == event.Pod.Name && event.Type != "DELETED" {
+		currentPod = *event.Pod
+	}
+	podsMu.Unlock()
+	podsChanged()
+}
+
+func getCurrentPod() Pod {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+
+	return currentPod
+}
+
+func setCurrentPod(pod Pod) {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	currentPod = pod
+}
+
+func getPodList() []*Pod {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+
+	return append([]*Pod{}, pods...)
+}
+
+func podsChanged() {
+	if currentOpenPods != nil {
+		currentOpenPods.Changed()
+	}
+	if currentOpenPod != nil {
+		currentOpenPod.Changed()
+	}
+	notifyTrayPods()
+}
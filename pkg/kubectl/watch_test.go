@@ -0,0 +1,84 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPodEvent(t *testing.T) {
+	podsMu.Lock()
+	previous, previousCurrent := pods, currentPod
+	pods, currentPod = []*Pod{}, Pod{Name: "b", Status: "Pending"}
+	podsMu.Unlock()
+	t.Cleanup(func() {
+		podsMu.Lock()
+		pods, currentPod = previous, previousCurrent
+		podsMu.Unlock()
+	})
+	names := func() []string {
+		list := []string{}
+		for _, pod := range getPodList() {
+			list = append(list, pod.Name)
+		}
+		return list
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, event := range []PodEvent{
+		{Type: "ADDED", Pod: &Pod{Name: "c"}},
+		{Type: "ADDED", Pod: &Pod{Name: "a"}},
+		{Type: "ADDED", Pod: &Pod{Name: "b", Status: "Pending"}},
+		{Type: "MODIFIED", Pod: &Pod{Name: "b", Status: "Running"}},
+		{Type: "DELETED", Pod: &Pod{Name: "a"}},
+	} {
+		applyPodEvent(ctx, event)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(names(), want) {
+		t.Errorf("pods = %q, want %q", names(), want)
+	}
+	if current := getCurrentPod(); current.Status != "Running" {
+		t.Errorf("current pod status = %q, want Running", current.Status)
+	}
+
+	// Events from a watch that has been restarted are dropped.
+	cancel()
+	applyPodEvent(ctx, PodEvent{Type: "ADDED", Pod: &Pod{Name: "other-namespace"}})
+	applyPodEvent(ctx, PodEvent{Type: "DELETED", Pod: &Pod{Name: "b"}})
+	if want := []string{"b", "c"}; !reflect.DeepEqual(names(), want) {
+		t.Errorf("pods after cancel = %q, want %q", names(), want)
+	}
+}
+
+// A failed reload keeps the last list rather than emptying the tray.
+func TestLoadPodsKeepsList(t *testing.T) {
+	podsMu.Lock()
+	previous := pods
+	pods = []*Pod{{Name: "api"}}
+	podsMu.Unlock()
+	previousOverride := backendOverride
+	t.Cleanup(func() {
+		podsMu.Lock()
+		pods = previous
+		podsMu.Unlock()
+		backendOverride = previousOverride
+	})
+
+	backendOverride = unavailableBackend{err: errors.New("connection refused")}
+	if err := loadPods(context.Background()); err == nil {
+		t.Error("loadPods() succeeded without a cluster")
+	}
+	if list := getPodList(); len(list) != 1 || list[0].Name != "api" {
+		t.Errorf("pods after a failed reload = %+v, want the last list", list)
+	}
+
+	replay(t)
+	backendOverride = testKubectlBackend()
+	if err := loadPods(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if list := getPodList(); len(list) != 6 || list[0].Name != "api-7d9f8b6c5-x2x4q" {
+		t.Errorf("pods after reload = %d, want the 6 recorded", len(list))
+	}
+}
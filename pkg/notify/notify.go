@@ -9,3 +9,8 @@ import (
 func Warning(title, context string) {
 	beeep.Notify(title, context, directory.Dir+"/assets/warning.png")
 }
+
+// Info creates an informational notification.
+func Info(title, context string) {
+	beeep.Notify(title, context, directory.Dir+"/assets/logo.png")
+}